@@ -0,0 +1,171 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// srvPrefix marks the addresses that are DNS SRV names, whose targets are
+// looked up on every scrape, e.g. srv+_olric._tcp.olric.default.svc.
+const srvPrefix = "srv+"
+
+// isSRV returns whether address is a DNS SRV name.
+func isSRV(address string) bool {
+	return strings.HasPrefix(address, srvPrefix)
+}
+
+// parseAddress checks the address of a server scraped with the given
+// transport and returns it in canonical form. It accepts host:port, with
+// IPv6 literals in brackets, olric:// and resp:// URLs, which are reduced to
+// their host:port, http:// and https:// URLs with the http transport, and
+// DNS SRV names prefixed with srv+.
+func parseAddress(address, transport string) (string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", fmt.Errorf("empty address")
+	}
+	if isSRV(address) {
+		name := strings.TrimSuffix(strings.TrimPrefix(address, srvPrefix), ".")
+		if !validHostname(name) {
+			return "", fmt.Errorf("invalid SRV name %q", name)
+		}
+		return srvPrefix + name, nil
+	}
+	if i := strings.Index(address, "://"); i >= 0 {
+		u, err := url.Parse(address)
+		if err != nil {
+			return "", fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		switch u.Scheme {
+		case "http", "https":
+			if transport != transportHTTP {
+				return "", fmt.Errorf("%s URL %q needs the %s transport", u.Scheme, address, transportHTTP)
+			}
+			if err := checkHost(u.Hostname()); err != nil {
+				return "", fmt.Errorf("invalid address %q: %w", address, err)
+			}
+			return address, nil
+		case transportOlric, transportRESP:
+			if u.Path != "" && u.Path != "/" || u.RawQuery != "" || u.User != nil {
+				return "", fmt.Errorf("%s URL %q must only have a host and a port", u.Scheme, address)
+			}
+			address = u.Host
+		default:
+			return "", fmt.Errorf("unsupported scheme %q in address %q", u.Scheme, address)
+		}
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		if strings.Count(address, ":") > 1 && !strings.HasPrefix(address, "[") {
+			return "", fmt.Errorf("invalid address %q: IPv6 addresses must be in brackets, e.g. [::1]:3320", address)
+		}
+		return "", fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q in address %q", port, address)
+	}
+	if err := checkHost(host); err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// checkHost checks that host is an IP address or a host name.
+func checkHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	if net.ParseIP(host) == nil && !validHostname(host) {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	return nil
+}
+
+// validHostname returns whether name is a valid DNS name, allowing the
+// underscores of SRV names.
+func validHostname(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// lookupSRV returns the host:port addresses of the targets of the SRV name
+// of address, ordered by priority and weight.
+func lookupSRV(ctx context.Context, address string) ([]string, error) {
+	name := strings.TrimPrefix(address, srvPrefix)
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %s: %w", name, err)
+	}
+	if len(records) == 0 {
+		return nil, &net.DNSError{Err: "no SRV records", Name: name, IsNotFound: true}
+	}
+	addresses := make([]string, 0, len(records))
+	for _, r := range records {
+		addresses = append(addresses, net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+	}
+	return addresses, nil
+}
+
+// expandSRV replaces the SRV names among addresses with their targets, for
+// the clients that keep the addresses they are created with.
+func expandSRV(addresses []string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var expanded []string
+	for _, address := range addresses {
+		if !isSRV(address) {
+			expanded = append(expanded, address)
+			continue
+		}
+		targets, err := lookupSRV(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, targets...)
+	}
+	return expanded, nil
+}
+
+// serverAddress returns address, or the first target of address if it is an
+// SRV name, for the requests to a single server.
+func serverAddress(ctx context.Context, address string) (string, error) {
+	if !isSRV(address) {
+		return address, nil
+	}
+	targets, err := lookupSRV(ctx, address)
+	if err != nil {
+		return "", err
+	}
+	return targets[0], nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		address   string
+		transport string
+		want      string
+		wantErr   bool
+	}{
+		{address: "localhost:3320", transport: transportOlric, want: "localhost:3320"},
+		{address: " 10.0.0.1:3320 ", transport: transportOlric, want: "10.0.0.1:3320"},
+		{address: "[::1]:3320", transport: transportOlric, want: "[::1]:3320"},
+		{address: "olric://olric-0.olric:3320", transport: transportOlric, want: "olric-0.olric:3320"},
+		{address: "resp://olric-0:3320/", transport: transportRESP, want: "olric-0:3320"},
+		{address: "http://shim:8080", transport: transportHTTP, want: "http://shim:8080"},
+		{address: "https://shim", transport: transportHTTP, want: "https://shim"},
+		{address: "srv+_olric._tcp.olric.default.svc.", transport: transportOlric, want: "srv+_olric._tcp.olric.default.svc"},
+		{address: "", transport: transportOlric, wantErr: true},
+		{address: "localhost", transport: transportOlric, wantErr: true},
+		{address: "::1:3320", transport: transportOlric, wantErr: true},
+		{address: "localhost:0", transport: transportOlric, wantErr: true},
+		{address: "localhost:65536", transport: transportOlric, wantErr: true},
+		{address: "localhost:http", transport: transportOlric, wantErr: true},
+		{address: ":3320", transport: transportOlric, wantErr: true},
+		{address: "bad_host!:3320", transport: transportOlric, wantErr: true},
+		{address: "http://shim:8080", transport: transportOlric, wantErr: true},
+		{address: "olric://olric-0:3320/stats", transport: transportOlric, wantErr: true},
+		{address: "olric://user@olric-0:3320", transport: transportOlric, wantErr: true},
+		{address: "ftp://olric-0:3320", transport: transportOlric, wantErr: true},
+		{address: "srv+bad..name", transport: transportOlric, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAddress(tt.address, tt.transport)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAddress(%q, %s) error = %v, want error %v", tt.address, tt.transport, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseAddress(%q, %s) = %q, want %q", tt.address, tt.transport, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// writeJSON encodes v as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, logger log.Logger, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		level.Error(logger).Log("msg", "Failed to write JSON response", "err", err)
+	}
+}
+
+// statsHandler serves the raw statistics of the target given in the target
+// URL parameter as JSON, or of the first target if none is given.
+func statsHandler(store *configStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := store.get()
+		t, ok := c.Targets[0], true
+		if address := r.URL.Query().Get("target"); address != "" {
+			t, ok = c.target(address)
+		}
+		if !ok {
+			http.Error(w, "Unknown target", http.StatusNotFound)
+			return
+		}
+		s, err := fetchStats(t.Address, c.Modules[t.Module])
+		if err != nil {
+			level.Error(logger).Log("msg", "Failed to collect stats from Olric", "target", t.Address, "err", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, logger, s)
+	}
+}
+
+// targetsHandler serves the list of configured targets as JSON. If token is
+// not empty, requests bearing it can add a target by posting it as JSON, and
+// remove a target added that way with DELETE and the target URL parameter.
+func targetsHandler(store *configStore, token string, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			writeJSON(w, logger, store.get().Targets)
+			return
+		case http.MethodPost, http.MethodDelete:
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token == "" {
+			http.Error(w, "The admin API is disabled", http.StatusForbidden)
+			return
+		}
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			address := r.URL.Query().Get("target")
+			ok, err := store.removeTarget(address)
+			if err != nil {
+				level.Error(logger).Log("msg", "Failed to remove target", "target", address, "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "Unknown target, only the targets added through the API can be removed", http.StatusNotFound)
+				return
+			}
+			level.Info(logger).Log("msg", "Removed target", "target", address)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		var t Target
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&t); err != nil {
+			http.Error(w, "Invalid target: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := store.addTarget(t); err != nil {
+			level.Error(logger).Log("msg", "Failed to add target", "target", t.Address, "err", err)
+			code := http.StatusBadRequest
+			if errors.As(err, new(persistError)) {
+				code = http.StatusInternalServerError
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		level.Info(logger).Log("msg", "Added target", "target", t.Address)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// refreshHandler answers POST requests by dropping the clients of the
+// target given in the target URL parameter, or of all targets, so that the
+// next scrape reconnects without waiting for the backoff delay, e.g. after a
+// failover. The cluster health of the targets and the metrics cached by ha,
+// if not nil, are forgotten too, so that no state from before is served. The
+// entry sizes are sampled again if sampler is not nil.
+func refreshHandler(token string, ha *haElector, sampler *sizeSampler, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token == "" {
+			http.Error(w, "The admin API is disabled", http.StatusForbidden)
+			return
+		}
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		address := r.URL.Query().Get("target")
+		n := clients.reset(address)
+		health.reset(address)
+		if ha != nil {
+			ha.reset()
+		}
+		if sampler != nil && (address == "" || address == sampler.target.Address) {
+			sampler.trigger()
+		}
+		level.Info(logger).Log("msg", "Refreshed clients", "target", address, "clients", n)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// authorized reports whether r bears token in its Authorization header.
+func authorized(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(token)) == 1
+}
+
+// corsHandler adds CORS headers to the responses of h for requests coming
+// from one of the allowed origins, and answers preflight requests.
+func corsHandler(h http.Handler, origins []string) http.Handler {
+	if len(origins) == 0 {
+		return h
+	}
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && (allowed["*"] || allowed[origin]) {
+			if allowed["*"] {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// bench collects the metrics of the Olric server at address n times and
+// writes the latency percentiles, allocations and payload sizes of a
+// collection to w. A collection includes the encoding of the metrics in the
+// text exposition format.
+func bench(w io.Writer, address string, module Module, n int, logger log.Logger) error {
+	if n <= 0 {
+		return fmt.Errorf("count must be positive")
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newExporter(context.Background(), Target{Address: address}, module, logger))
+
+	var (
+		durations = make([]time.Duration, 0, n)
+		total     time.Duration
+		size      int
+		payload   bytes.Buffer
+		before    runtime.MemStats
+		after     runtime.MemStats
+	)
+	runtime.ReadMemStats(&before)
+	for i := 0; i < n; i++ {
+		payload.Reset()
+		start := time.Now()
+		families, err := registry.Gather()
+		if err != nil {
+			return err
+		}
+		enc := expfmt.NewEncoder(&payload, expfmt.FmtText)
+		for _, mf := range families {
+			if down := downMembers(mf); len(down) > 0 {
+				return fmt.Errorf("members %s could not be scraped", strings.Join(down, ", "))
+			}
+			if err := enc.Encode(mf); err != nil {
+				return err
+			}
+		}
+		d := time.Since(start)
+		durations = append(durations, d)
+		total += d
+		size += payload.Len()
+	}
+	runtime.ReadMemStats(&after)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(payload.Bytes()); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	fmt.Fprintf(w, "collections:   %d\n", n)
+	fmt.Fprintf(w, "latency:       mean=%s p50=%s p90=%s p99=%s max=%s\n",
+		total/time.Duration(n), percentile(durations, 0.5), percentile(durations, 0.9),
+		percentile(durations, 0.99), durations[len(durations)-1])
+	fmt.Fprintf(w, "allocations:   %d allocs/op, %d B/op\n",
+		(after.Mallocs-before.Mallocs)/uint64(n), (after.TotalAlloc-before.TotalAlloc)/uint64(n))
+	fmt.Fprintf(w, "payload:       %d B/op, %d B gzipped\n", size/n, compressed.Len())
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Exit codes of the check subcommand, as expected by Nagios and Sensu.
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStatus = map[int]string{
+	checkOK:       "OK",
+	checkWarning:  "WARNING",
+	checkCritical: "CRITICAL",
+	checkUnknown:  "UNKNOWN",
+}
+
+// checkConfig holds the thresholds of the check subcommand.
+type checkConfig struct {
+	// Warning and Critical are the numbers of members below which the check
+	// is warning and critical.
+	Warning, Critical int
+
+	// HitRatioWarning and HitRatioCritical are the DMap get hit ratios below
+	// which the check is warning and critical. 0 disables them.
+	HitRatioWarning, HitRatioCritical float64
+}
+
+// check scrapes the cluster of t, writes a one-line summary with performance
+// data to w and returns the exit code. The cluster is critical if no member
+// can be reached or fewer than critical members are up, and warning if any
+// member is down or fewer than warning members are up. If hit ratio
+// thresholds are set, the hit ratio of the DMap gets served since the members
+// started is checked as well.
+func check(w io.Writer, t Target, module Module, c checkConfig) int {
+	warning, critical := c.Warning, c.Critical
+	if warning < critical {
+		fmt.Fprintf(w, "OLRIC %s - the warning threshold %d is below the critical threshold %d\n",
+			checkStatus[checkUnknown], warning, critical)
+		return checkUnknown
+	}
+	if c.HitRatioWarning < c.HitRatioCritical {
+		fmt.Fprintf(w, "OLRIC %s - the hit ratio warning threshold %s is below the critical threshold %s\n",
+			checkStatus[checkUnknown], formatRatio(c.HitRatioWarning), formatRatio(c.HitRatioCritical))
+		return checkUnknown
+	}
+
+	results := fetchCluster(context.Background(), t, module)
+	if len(results) == 0 {
+		fmt.Fprintf(w, "OLRIC %s - no member of %s was found\n", checkStatus[checkUnknown], t.Address)
+		return checkUnknown
+	}
+	var up int
+	var down []string
+	var hits, misses int64
+	counted := false
+	coordinator := ""
+	for _, ms := range results {
+		if ms.Err != nil {
+			down = append(down, ms.Member)
+			continue
+		}
+		up++
+		if ms.Counters != nil {
+			hits += ms.Counters.GetHits
+			misses += ms.Counters.GetMisses
+			counted = true
+		}
+		if coordinator == "" {
+			coordinator = ms.Stats.ClusterCoordinator.Name
+		}
+	}
+	sort.Strings(down)
+
+	status := checkOK
+	var problems []string
+	switch {
+	case up == 0:
+		status = checkCritical
+		problems = append(problems, fmt.Sprintf("%s cannot be reached: %v", t.Address, results[0].Err))
+	case up < critical:
+		status = checkCritical
+		problems = append(problems, fmt.Sprintf("quorum lost, %d members required", critical))
+	case up < warning:
+		status = checkWarning
+		problems = append(problems, fmt.Sprintf("%d members expected", warning))
+	case len(down) > 0:
+		status = checkWarning
+	}
+	if len(down) > 0 && up > 0 {
+		problems = append(problems, "down: "+strings.Join(down, ", "))
+	}
+
+	ratio := -1.0
+	if counted && hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+	if c.HitRatioWarning > 0 && up > 0 {
+		switch {
+		case !counted:
+			if status == checkOK {
+				status = checkUnknown
+			}
+			problems = append(problems, "no member reports the hit ratio")
+		case ratio < 0:
+		case ratio < c.HitRatioCritical:
+			status = checkCritical
+			problems = append(problems, fmt.Sprintf("hit ratio %s below %s", formatRatio(ratio), formatRatio(c.HitRatioCritical)))
+		case ratio < c.HitRatioWarning:
+			if status == checkOK {
+				status = checkWarning
+			}
+			problems = append(problems, fmt.Sprintf("hit ratio %s below %s", formatRatio(ratio), formatRatio(c.HitRatioWarning)))
+		}
+	}
+
+	summary := fmt.Sprintf("%d/%d members up", up, len(results))
+	if coordinator != "" {
+		summary += ", coordinator " + coordinator
+	}
+	if len(problems) > 0 {
+		summary += ", " + strings.Join(problems, ", ")
+	}
+	perf := fmt.Sprintf("members_up=%d;%d:;%d: members=%d", up, warning, critical, len(results))
+	if ratio >= 0 {
+		perf += " hit_ratio=" + formatRatio(ratio)
+		if c.HitRatioWarning > 0 {
+			perf += fmt.Sprintf(";%s:;%s:", formatRatio(c.HitRatioWarning), formatRatio(c.HitRatioCritical))
+		}
+	}
+	fmt.Fprintf(w, "OLRIC %s - %s | %s\n", checkStatus[status], summary, perf)
+	return status
+}
+
+// formatRatio formats a ratio for the summary and the performance data.
+func formatRatio(r float64) string {
+	return strconv.FormatFloat(r, 'f', -1, 64)
+}
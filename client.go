@@ -0,0 +1,374 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/buraksezer/olric/client"
+	"github.com/buraksezer/olric/stats"
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	scrapeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "olric_exporter",
+		Name:      "scrape_errors_total",
+		Help:      "Number of members whose collection failed, including timeouts.",
+	}, []string{"target"})
+	scrapeTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "olric_exporter",
+		Name:      "scrape_timeouts_total",
+		Help:      "Number of members whose collection hit the collection timeout.",
+	}, []string{"target"})
+)
+
+const samplesCollectedName, samplesCollectedHelp = "olric_exporter_samples_collected",
+	"Number of samples produced by the last collection of a target, before the metric rules and the series limit."
+
+const (
+	// scopeLocal scrapes only the target, e.g. from a sidecar.
+	scopeLocal = "local"
+
+	// scopeCluster scrapes all members of the cluster of the target.
+	scopeCluster = "cluster"
+)
+
+// timeoutError is returned if a collection does not finish in time.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("collection timed out after %s", e.timeout)
+}
+
+// Reason implements the classification of exporter.ErrorReason.
+func (e timeoutError) Reason() string {
+	return "timeout"
+}
+
+// isTimeout returns whether err is caused by a deadline, either of the whole
+// collection or of a network operation.
+func isTimeout(err error) bool {
+	var te timeoutError
+	if errors.As(err, &te) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// olricClient is the exporter.StatsClient of the Olric v0.3 protocol.
+type olricClient struct {
+	c      *client.Client
+	target string
+}
+
+// newOlricMu serializes the creation of the Olric clients, since client.New
+// sets a package variable of the client.
+var newOlricMu sync.Mutex
+
+// newOlricClient returns a client.New of cc, safe for concurrent use.
+func newOlricClient(cc *client.Config) (*client.Client, error) {
+	newOlricMu.Lock()
+	defer newOlricMu.Unlock()
+	return client.New(cc)
+}
+
+func newClient(address string, module Module) (*olricClient, error) {
+	cc := &client.Config{
+		Addrs:       []string{address},
+		MaxConn:     module.MaxConn,
+		Serializer:  serializers[module.Serializer](),
+		DialTimeout: module.dialTimeout(),
+		KeepAlive:   module.KeepAlive,
+	}
+	c, err := newOlricClient(cc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Olric: %w", err)
+	}
+	return &olricClient{c: c, target: address}, nil
+}
+
+// Stats implements exporter.StatsClient. The Olric client does not support
+// cancellation, so the request is abandoned when ctx is done.
+func (o *olricClient) Stats(ctx context.Context, address string) (stats.Stats, error) {
+	type result struct {
+		stats stats.Stats
+		err   error
+	}
+	done := make(chan result, 1)
+	inUse := clientConnsInUse.WithLabelValues(o.target)
+	inUse.Inc()
+	go func() {
+		defer inUse.Dec()
+		s, err := o.c.Stats(address)
+		done <- result{stats: s, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.stats, res.err
+	case <-ctx.Done():
+		return stats.Stats{}, ctx.Err()
+	}
+}
+
+// Ping checks a connection to the member at address.
+func (o *olricClient) Ping(ctx context.Context, address string) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- o.c.Ping(address)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (o *olricClient) Close() {
+	o.c.Close()
+}
+
+// collectionError replaces the error of a collection that ran out of time
+// with a timeoutError.
+func collectionError(err error, timeout time.Duration) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return timeoutError{timeout: timeout}
+	}
+	return err
+}
+
+// fetchStats retrieves the statistics of the Olric server at address, or of
+// the first target of address if it is an SRV name. The whole operation,
+// including the lookup and connection establishment, is bounded by the
+// timeout of the module.
+func fetchStats(address string, module Module) (stats.Stats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), module.Timeout)
+	defer cancel()
+	address, err := serverAddress(ctx, address)
+	if err != nil {
+		return stats.Stats{}, collectionError(err, module.Timeout)
+	}
+	c, err := newStatsClient(address, module)
+	if err != nil {
+		return stats.Stats{}, err
+	}
+	defer c.Close()
+	s, err := c.Stats(ctx, address)
+	return s, collectionError(err, module.Timeout)
+}
+
+// rotation counts the scrapes of the round robin targets.
+type rotation struct {
+	mu    sync.Mutex
+	count map[string]int
+}
+
+var rotations = &rotation{count: make(map[string]int)}
+
+// order returns the addresses of t in the order they are tried.
+func (r *rotation) order(t Target) []string {
+	addresses := append([]string{t.Address}, t.Seeds...)
+	if !t.RoundRobin || len(addresses) == 1 {
+		return addresses
+	}
+	r.mu.Lock()
+	n := r.count[t.Address]
+	r.count[t.Address] = n + 1
+	r.mu.Unlock()
+	n %= len(addresses)
+	return append(addresses[n:], addresses[:n]...)
+}
+
+// fetchCluster retrieves the statistics of every member of the cluster of
+// target, see exporter.ClusterStats. If the address of target cannot be
+// reached, its seeds are tried in order, starting from the next one on every
+// scrape if the target is round robin. The whole operation is bounded by
+// the timeout of the module. If no seed can be reached, all of them are
+// reported down.
+func fetchCluster(ctx context.Context, t Target, module Module) []exporter.MemberStats {
+	ctx, cancel := context.WithTimeout(ctx, module.Timeout)
+	defer cancel()
+	var failed []exporter.MemberStats
+	for _, address := range rotations.order(t) {
+		seeds := []string{address}
+		if isSRV(address) {
+			// The targets of SRV names are looked up on every scrape, so
+			// that rescheduled servers are followed.
+			var err error
+			// lookupSRV fails if the name has no targets, so the name
+			// is reported down rather than no member at all.
+			if seeds, err = lookupSRV(ctx, address); err != nil {
+				failed = append(failed, exporter.MemberStats{Member: address, Err: collectionError(err, module.Timeout)})
+				continue
+			}
+		}
+		for _, seed := range seeds {
+			results, ok := fetchSeed(ctx, t.Address, seed, module)
+			for i := range results {
+				results[i].Err = collectionError(results[i].Err, module.Timeout)
+			}
+			if ok {
+				return results
+			}
+			failed = append(failed, results...)
+			if ctx.Err() != nil {
+				return failed
+			}
+		}
+	}
+	return failed
+}
+
+// fetchSeed retrieves the statistics of the cluster through the Olric server
+// at address, and reports whether it could be reached. Only address is
+// scraped with the local scope, and with the http transport, since the
+// routing table only holds the Olric addresses of the members. The client
+// of address is kept in the pool for the next scrapes. The stats requests are
+// timed for target.
+func fetchSeed(ctx context.Context, target, address string, module Module) ([]exporter.MemberStats, bool) {
+	c, err := clients.get(address, module)
+	if err != nil {
+		return []exporter.MemberStats{{Member: address, Err: err}}, false
+	}
+	tc := timed(c, target)
+	var results []exporter.MemberStats
+	if module.Scope == scopeLocal || module.Transport == transportHTTP {
+		s, counters, err := tc.StatsWithCounters(ctx, address)
+		results = []exporter.MemberStats{{Member: address, Stats: s, Err: err, Counters: counters}}
+	} else {
+		results = exporter.ClusterStats(ctx, tc, address)
+	}
+	// Only the failure of address itself means its client is broken, the
+	// other members are connected on demand by the client.
+	var seedErr error
+	if len(results) == 1 && results[0].Member == address {
+		seedErr = results[0].Err
+	}
+	clients.report(address, module, c, seedErr)
+	if seedErr == nil {
+		var failed bool
+		for _, ms := range results {
+			failed = failed || ms.Err != nil
+		}
+		clients.watch(address, module, c, failed)
+	}
+	return results, seedErr == nil
+}
+
+// downMembers returns the members reported down by mf, if it is the member up
+// metric family.
+func downMembers(mf *dto.MetricFamily) []string {
+	if mf.GetName() != exporter.Namespace+"_member_up" {
+		return nil
+	}
+	var down []string
+	for _, m := range mf.GetMetric() {
+		if m.GetGauge().GetValue() == 1 {
+			continue
+		}
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "member" {
+				down = append(down, l.GetValue())
+			}
+		}
+	}
+	return down
+}
+
+// newExporter returns an exporter of the cluster of target, scraped with the
+// given module within ctx. The options are applied after the ones of the
+// module.
+func newExporter(ctx context.Context, t Target, module Module, logger log.Logger, opts ...exporter.Option) *exporter.Exporter {
+	return exporter.New(func() []exporter.MemberStats {
+		return fetchTarget(ctx, t, module)
+	}, module.Collectors, logger, append(module.options(), opts...)...)
+}
+
+// fetchTarget fetches the stats of the cluster of t, names its members and
+// records the outcome in the metrics of the exporter and the cluster health.
+func fetchTarget(ctx context.Context, t Target, module Module) []exporter.MemberStats {
+	results := fetchCluster(ctx, t, module)
+	if memberNames != nil {
+		memberNames.rename(results)
+	}
+	if module.MemberLabels.enabled() {
+		renameMembers(results, module.MemberLabels.name)
+	}
+	coordinators.observe(t.Address, results)
+	health.observe(t.Address, results)
+	for _, ms := range results {
+		if ms.Err != nil {
+			scrapeErrors.WithLabelValues(t.Address).Inc()
+			if isTimeout(ms.Err) {
+				scrapeTimeouts.WithLabelValues(t.Address).Inc()
+			}
+		}
+	}
+	return results
+}
+
+// sampleCounter exports the number of samples collected by the collector of
+// a target.
+type sampleCounter struct {
+	prometheus.Collector
+	desc   *prometheus.Desc
+	target []string
+}
+
+// newSampleCounter returns a sampleCounter of c, the collector of target. The
+// target label is left out if it is among the labels c is registered with.
+func newSampleCounter(c prometheus.Collector, target string, labels prometheus.Labels) sampleCounter {
+	if _, ok := labels["target"]; ok {
+		return sampleCounter{Collector: c, desc: prometheus.NewDesc(samplesCollectedName, samplesCollectedHelp, nil, nil)}
+	}
+	return sampleCounter{
+		Collector: c,
+		desc:      prometheus.NewDesc(samplesCollectedName, samplesCollectedHelp, []string{"target"}, nil),
+		target:    []string{target},
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c sampleCounter) Describe(ch chan<- *prometheus.Desc) {
+	c.Collector.Describe(ch)
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c sampleCounter) Collect(ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.Collector.Collect(metrics)
+		close(metrics)
+	}()
+	var n int
+	for m := range metrics {
+		ch <- m
+		n++
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), c.target...)
+}
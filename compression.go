@@ -0,0 +1,81 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// gzipResponseWriter sends everything written to it through a gzip.Writer.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	w io.Writer
+}
+
+func (g gzipResponseWriter) Write(p []byte) (int, error) {
+	return g.w.Write(p)
+}
+
+// validateCompressionLevel checks that level is accepted by compress/gzip.
+func validateCompressionLevel(level int) error {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return fmt.Errorf("invalid compression level %d, must be between %d and %d",
+			level, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	return nil
+}
+
+// gzipHandler compresses the responses of h with the given gzip level if the
+// client accepts gzip encoding.
+func gzipHandler(h http.Handler, level int) http.Handler {
+	pool := &sync.Pool{
+		New: func() interface{} {
+			// The level is validated at startup.
+			w, _ := gzip.NewWriterLevel(nil, level)
+			return w
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !gzipAccepted(r.Header) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		gz := pool.Get().(*gzip.Writer)
+		defer pool.Put(gz)
+		gz.Reset(w)
+		defer gz.Close()
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Add("Vary", "Accept-Encoding")
+		h.ServeHTTP(gzipResponseWriter{ResponseWriter: w, w: gz}, r)
+	})
+}
+
+// gzipAccepted returns whether the client will accept gzip-encoded content.
+func gzipAccepted(header http.Header) bool {
+	for _, part := range strings.Split(header.Get("Accept-Encoding"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "gzip" || strings.HasPrefix(part, "gzip;") {
+			return true
+		}
+	}
+	return false
+}
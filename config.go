@@ -0,0 +1,662 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buraksezer/olric/serializer"
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultModule = "default"
+
+// serializers maps the serializer names accepted in the configuration to
+// their constructors.
+var serializers = map[string]func() serializer.Serializer{
+	"msgpack": serializer.NewMsgpackSerializer,
+	"json":    serializer.NewJSONSerializer,
+	"gob":     serializer.NewGobSerializer,
+}
+
+var (
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "olric_exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful.",
+	})
+	configReloadSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "olric_exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+)
+
+// reservedLabels are the labels set by the exporter, which cannot be used as
+// target labels.
+var reservedLabels = func() map[string]bool {
+	reserved := map[string]bool{"target": true}
+	for _, info := range exporter.New(nil, nil, log.NewNopLogger()).MetricInfos() {
+		for _, l := range info.Labels {
+			reserved[l] = true
+		}
+	}
+	return reserved
+}()
+
+// Config is the configuration file of the exporter.
+type Config struct {
+	Modules map[string]Module `yaml:"modules"`
+
+	// Targets are the Olric servers scraped on /metrics. If the file
+	// defines none, the server given in --olric.address is scraped, with
+	// the seeds given in --olric.seed.
+	Targets []Target `yaml:"targets,omitempty"`
+
+	// MetricRules rename, drop or label metrics before they are exposed.
+	MetricRules []MetricRule `yaml:"metric_rules,omitempty"`
+
+	// labelTargets is set if the targets come from the file, in which
+	// case their metrics are told apart with a target label.
+	labelTargets bool
+}
+
+// Target is an Olric server scraped on /metrics.
+type Target struct {
+	Address string `yaml:"address" json:"address"`
+
+	// Module is the name of the module used to scrape the server.
+	Module string `yaml:"module,omitempty" json:"module"`
+
+	// Seeds are further addresses of the same cluster, tried in order if
+	// the server cannot be reached.
+	Seeds []string `yaml:"seeds,omitempty" json:"seeds,omitempty"`
+
+	// RoundRobin starts every scrape with the next of the address and the
+	// seeds, to spread the load of the scrapes over them.
+	RoundRobin bool `yaml:"round_robin,omitempty" json:"round_robin,omitempty"`
+
+	// Labels are attached to all metrics of the server.
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+}
+
+// Module describes how an Olric server is scraped. Modules are selected on
+// /probe with the module URL parameter.
+type Module struct {
+	// Timeout bounds the whole collection, including connection establishment.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// DialTimeout bounds connection establishment. Timeout is used if it
+	// is not set.
+	DialTimeout time.Duration `yaml:"dial_timeout,omitempty"`
+
+	// ReadTimeout and WriteTimeout bound every read and write of the resp
+	// transport, and the wait for the response of the http transport. The
+	// Olric v0.3 client does not let them be set.
+	ReadTimeout  time.Duration `yaml:"read_timeout,omitempty"`
+	WriteTimeout time.Duration `yaml:"write_timeout,omitempty"`
+
+	// Serializer is the name of the serializer used by the Olric cluster.
+	Serializer string `yaml:"serializer"`
+
+	// MaxConn is the maximum number of connections opened to the server.
+	// The resp transport ignores it, since it dials a connection for every
+	// command.
+	MaxConn int `yaml:"max_conn"`
+
+	// KeepAlive is the keep-alive period of the connections, ignored by the
+	// resp transport.
+	KeepAlive time.Duration `yaml:"keep_alive"`
+
+	// Collectors lists the enabled groups of metrics. All of them are
+	// enabled if it is empty.
+	Collectors []string `yaml:"collectors"`
+
+	// Transport is the protocol used to fetch the statistics: olric for
+	// Olric v0.3, resp for the Redis protocol of Olric v0.5 and later, or
+	// http.
+	Transport string `yaml:"transport"`
+
+	// HTTPPath is the path of the statistics served as JSON by the target
+	// with the http transport.
+	HTTPPath string `yaml:"http_path,omitempty"`
+
+	// Scope is local to scrape only the target, or cluster to scrape all
+	// members of its cluster.
+	Scope string `yaml:"scope,omitempty"`
+
+	// Compat also emits the metrics of the previous release under their
+	// former names. The switches are pointers so that false in a module
+	// overrides a flag set to true.
+	Compat *bool `yaml:"compat,omitempty"`
+
+	// NormalizedNames makes the metric names follow the Prometheus naming
+	// conventions.
+	NormalizedNames *bool `yaml:"normalized_names,omitempty"`
+
+	// RoutingTable also exports the owner of every partition.
+	RoutingTable *bool `yaml:"routing_table,omitempty"`
+
+	// MemberLabels normalizes the member labels.
+	MemberLabels MemberLabels `yaml:"member_labels,omitempty"`
+}
+
+// MemberLabels normalizes the member labels, e.g. to match the instance
+// labels of other exporters. The member addresses, or their host names with
+// --olric.resolve-members, are looked up in Names first; the other members
+// have their port stripped and are lowercased if set.
+type MemberLabels struct {
+	// StripPort removes the port of the members.
+	StripPort bool `yaml:"strip_port,omitempty"`
+
+	// Lowercase lowercases the members.
+	Lowercase bool `yaml:"lowercase,omitempty"`
+
+	// Names maps members to their labels.
+	Names map[string]string `yaml:"names,omitempty"`
+}
+
+// enabled returns whether l changes any member label.
+func (l MemberLabels) enabled() bool {
+	return l.StripPort || l.Lowercase || len(l.Names) > 0
+}
+
+// name returns the label of the member at address.
+func (l MemberLabels) name(address string) string {
+	if n, ok := l.Names[address]; ok {
+		return n
+	}
+	if l.StripPort {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			address = host
+		}
+	}
+	if l.Lowercase {
+		address = strings.ToLower(address)
+	}
+	return address
+}
+
+// withDefaults fills the unset fields of m from defaults.
+func (m Module) withDefaults(defaults Module) Module {
+	if m.Timeout == 0 {
+		m.Timeout = defaults.Timeout
+	}
+	if m.DialTimeout == 0 {
+		m.DialTimeout = defaults.DialTimeout
+	}
+	if m.ReadTimeout == 0 {
+		m.ReadTimeout = defaults.ReadTimeout
+	}
+	if m.WriteTimeout == 0 {
+		m.WriteTimeout = defaults.WriteTimeout
+	}
+	if m.Serializer == "" {
+		m.Serializer = defaults.Serializer
+	}
+	if m.MaxConn == 0 {
+		m.MaxConn = defaults.MaxConn
+	}
+	if m.KeepAlive == 0 {
+		m.KeepAlive = defaults.KeepAlive
+	}
+	if len(m.Collectors) == 0 {
+		m.Collectors = defaults.Collectors
+	}
+	if m.Transport == "" {
+		m.Transport = defaults.Transport
+	}
+	if m.HTTPPath == "" {
+		m.HTTPPath = defaults.HTTPPath
+	}
+	if m.Scope == "" {
+		m.Scope = defaults.Scope
+	}
+	if m.Compat == nil {
+		m.Compat = defaults.Compat
+	}
+	if m.NormalizedNames == nil {
+		m.NormalizedNames = defaults.NormalizedNames
+	}
+	if m.RoutingTable == nil {
+		m.RoutingTable = defaults.RoutingTable
+	}
+	return m
+}
+
+// enabled returns whether the switch b of a module is set to true.
+func enabled(b *bool) bool {
+	return b != nil && *b
+}
+
+// dialTimeout returns the timeout of connection establishment.
+func (m Module) dialTimeout() time.Duration {
+	if m.DialTimeout > 0 {
+		return m.DialTimeout
+	}
+	return m.Timeout
+}
+
+// options returns the exporter options selected by m.
+func (m Module) options() []exporter.Option {
+	var opts []exporter.Option
+	if enabled(m.Compat) {
+		opts = append(opts, exporter.WithCompat())
+	}
+	if enabled(m.NormalizedNames) {
+		opts = append(opts, exporter.WithNormalizedNames())
+	}
+	if enabled(m.RoutingTable) {
+		opts = append(opts, exporter.WithRoutingTable())
+	}
+	return opts
+}
+
+// checkTimeouts rejects the read and write timeouts set in a module of the
+// file that its transport does not support. It is called before the flags
+// are merged, which apply to all modules.
+func (m Module) checkTimeouts() error {
+	switch m.Transport {
+	case "", transportOlric:
+		if m.ReadTimeout != 0 || m.WriteTimeout != 0 {
+			return fmt.Errorf("read_timeout and write_timeout are not supported by the %s transport", transportOlric)
+		}
+	case transportHTTP:
+		if m.WriteTimeout != 0 {
+			return fmt.Errorf("write_timeout is not supported by the %s transport", transportHTTP)
+		}
+	}
+	return nil
+}
+
+func (m Module) validate() error {
+	if m.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if m.DialTimeout < 0 || m.ReadTimeout < 0 || m.WriteTimeout < 0 {
+		return fmt.Errorf("dial, read and write timeouts must not be negative")
+	}
+	if _, ok := serializers[m.Serializer]; !ok {
+		return fmt.Errorf("unknown serializer %q", m.Serializer)
+	}
+	if m.MaxConn <= 0 {
+		return fmt.Errorf("max_conn must be positive")
+	}
+	if m.Transport != transportOlric && m.Transport != transportHTTP && m.Transport != transportRESP {
+		return fmt.Errorf("unknown transport %q", m.Transport)
+	}
+	if m.Scope != scopeLocal && m.Scope != scopeCluster {
+		return fmt.Errorf("unknown scope %q", m.Scope)
+	}
+	if m.Transport == transportHTTP && !strings.HasPrefix(m.HTTPPath, "/") {
+		return fmt.Errorf("http_path must start with /")
+	}
+	for _, name := range m.Collectors {
+		if !exporter.IsCollector(name) {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	return nil
+}
+
+// loadConfig reads the configuration at path, see newConfigSource. Unset
+// module fields are taken from defaults, which is also used as the default
+// module unless the configuration defines one. If it defines no targets, def
+// is scraped with the default module.
+func loadConfig(path string, defaults Module, def Target) (*Config, error) {
+	var data []byte
+	if path != "" {
+		src, err := newConfigSource(path, 0)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = src.read(); err != nil {
+			return nil, err
+		}
+	}
+	return parseConfig(data, defaults, def)
+}
+
+func parseConfig(data []byte, defaults Module, def Target) (*Config, error) {
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %w", err)
+	}
+	if c.Modules == nil {
+		c.Modules = make(map[string]Module)
+	}
+	for name, m := range c.Modules {
+		if err := m.checkTimeouts(); err != nil {
+			return nil, fmt.Errorf("invalid module %q: %w", name, err)
+		}
+	}
+	if _, ok := c.Modules[defaultModule]; !ok {
+		c.Modules[defaultModule] = defaults
+	}
+	for name, m := range c.Modules {
+		m = m.withDefaults(defaults)
+		if err := m.validate(); err != nil {
+			return nil, fmt.Errorf("invalid module %q: %w", name, err)
+		}
+		c.Modules[name] = m
+	}
+
+	if len(c.Targets) == 0 {
+		c.Targets = []Target{def}
+	} else {
+		c.labelTargets = true
+	}
+	seen := make(map[string]bool, len(c.Targets))
+	for i := range c.Targets {
+		if err := c.validateTarget(&c.Targets[i], seen); err != nil {
+			return nil, fmt.Errorf("invalid target %d: %w", i, err)
+		}
+	}
+	for i := range c.MetricRules {
+		if err := c.MetricRules[i].compile(); err != nil {
+			return nil, fmt.Errorf("invalid metric rule %d: %w", i, err)
+		}
+	}
+	return c, nil
+}
+
+// validateTarget checks t against the modules of c and the addresses already
+// seen, and sets its default module.
+func (c *Config) validateTarget(t *Target, seen map[string]bool) error {
+	if t.Address == "" {
+		return fmt.Errorf("target has no address")
+	}
+	if t.Module == "" {
+		t.Module = defaultModule
+	}
+	module, ok := c.Modules[t.Module]
+	if !ok {
+		return fmt.Errorf("unknown module %q of target %q", t.Module, t.Address)
+	}
+	address, err := parseAddress(t.Address, module.Transport)
+	if err != nil {
+		return err
+	}
+	t.Address = address
+	for i, seed := range t.Seeds {
+		if t.Seeds[i], err = parseAddress(seed, module.Transport); err != nil {
+			return fmt.Errorf("invalid seed of target %q: %w", t.Address, err)
+		}
+	}
+	if seen[t.Address] {
+		return fmt.Errorf("duplicate target %q", t.Address)
+	}
+	seen[t.Address] = true
+	for name := range t.Labels {
+		if !model.LabelName(name).IsValid() || reservedLabels[name] {
+			return fmt.Errorf("invalid label %q of target %q", name, t.Address)
+		}
+	}
+	return nil
+}
+
+// withTargets returns a copy of c that also scrapes the given targets.
+func (c *Config) withTargets(targets []Target) (*Config, error) {
+	if len(targets) == 0 {
+		return c, nil
+	}
+	merged := *c
+	merged.Targets = make([]Target, 0, len(c.Targets)+len(targets))
+	merged.Targets = append(merged.Targets, c.Targets...)
+	seen := make(map[string]bool, len(merged.Targets))
+	for _, t := range c.Targets {
+		seen[t.Address] = true
+	}
+	for _, t := range targets {
+		if err := merged.validateTarget(&t, seen); err != nil {
+			return nil, err
+		}
+		merged.Targets = append(merged.Targets, t)
+	}
+	merged.labelTargets = true
+	return &merged, nil
+}
+
+// target returns the configured target with the given address.
+func (c *Config) target(address string) (Target, bool) {
+	for _, t := range c.Targets {
+		if t.Address == address {
+			return t, true
+		}
+	}
+	return Target{}, false
+}
+
+// configStore holds the current configuration. It is replaced as a whole
+// on reload, so readers never see a partially applied configuration. The
+// targets added at runtime are kept across reloads, and written to path if
+// it is not empty.
+type configStore struct {
+	mu      sync.RWMutex
+	base    *Config
+	config  *Config
+	path    string
+	targets []Target
+}
+
+func newConfigStore(c *Config) *configStore {
+	return &configStore{base: c, config: c}
+}
+
+// loadTargets adds the targets persisted at path to the configuration, and
+// persists the targets added later there. A missing file holds no targets.
+func (s *configStore) loadTargets(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var targets []Target
+	if err := yaml.UnmarshalStrict(data, &targets); err != nil {
+		return fmt.Errorf("error parsing targets file: %w", err)
+	}
+	return s.apply(s.base, targets)
+}
+
+func (s *configStore) get() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// set replaces the configuration, keeping the targets added at runtime
+// except the ones c now defines, whose addresses are returned. They are
+// dropped rather than failing the reload as duplicates.
+func (s *configStore) set(c *Config) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defined := make(map[string]bool, len(c.Targets))
+	for _, t := range c.Targets {
+		defined[t.Address] = true
+	}
+	var targets []Target
+	var dropped []string
+	for _, t := range s.targets {
+		if defined[t.Address] {
+			dropped = append(dropped, t.Address)
+			continue
+		}
+		targets = append(targets, t)
+	}
+	if err := s.apply(c, targets); err != nil {
+		return nil, err
+	}
+	if len(dropped) == 0 {
+		return nil, nil
+	}
+	if err := s.persist(targets); err != nil {
+		return dropped, fmt.Errorf("config applied, but persisting the targets failed: %w", err)
+	}
+	return dropped, nil
+}
+
+// apply merges base and targets into the current configuration.
+func (s *configStore) apply(base *Config, targets []Target) error {
+	c, err := base.withTargets(targets)
+	if err != nil {
+		return err
+	}
+	s.base, s.config, s.targets = base, c, targets
+	return nil
+}
+
+// runtimeTargets returns the targets added at runtime.
+func (s *configStore) runtimeTargets() []Target {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.targets
+}
+
+// addTarget adds t to the targets added at runtime. The targets are
+// persisted before they are applied, so that a target is only scraped once
+// it survives a restart. The errors of persisting are persistErrors.
+func (s *configStore) addTarget(t Target) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(append(append([]Target(nil), s.targets...), t))
+}
+
+// removeTarget removes the target added at runtime with the given address,
+// and reports whether it existed.
+func (s *configStore) removeTarget(address string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	targets := make([]Target, 0, len(s.targets))
+	for _, t := range s.targets {
+		if t.Address != address {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == len(s.targets) {
+		return false, nil
+	}
+	return true, s.update(targets)
+}
+
+// update replaces the targets added at runtime if they are valid and could
+// be persisted.
+func (s *configStore) update(targets []Target) error {
+	c, err := s.base.withTargets(targets)
+	if err != nil {
+		return err
+	}
+	if err := s.persist(targets); err != nil {
+		return persistError{err: err}
+	}
+	s.config, s.targets = c, targets
+	return nil
+}
+
+// persistError is returned if the targets added at runtime could not be
+// written to the targets file.
+type persistError struct {
+	err error
+}
+
+func (e persistError) Error() string {
+	return fmt.Sprintf("error persisting the targets: %s", e.err)
+}
+
+func (e persistError) Unwrap() error {
+	return e.err
+}
+
+// persist writes targets to the targets file. The file is replaced
+// atomically, so it is never read half-written.
+func (s *configStore) persist(targets []Target) error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := yaml.Marshal(targets)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// watch applies the configuration of src whenever its content changes. The
+// content is compared instead of the modification time since a Kubernetes
+// ConfigMap volume replaces the file through a symlink swap. Invalid
+// configurations are logged and ignored, and reading is retried every
+// interval after an error.
+func (s *configStore) watch(path string, src configSource, defaults Module, def Target, interval time.Duration, logger log.Logger) {
+	last, err := src.read()
+	if err != nil {
+		level.Error(logger).Log("msg", "Error reading config file", "file", path, "err", err)
+		configReloadSuccess.Set(0)
+	} else {
+		configReloadSuccess.Set(1)
+		configReloadSeconds.SetToCurrentTime()
+	}
+
+	for {
+		data, err := src.next()
+		if err != nil {
+			level.Error(logger).Log("msg", "Error reading config file", "file", path, "err", err)
+			configReloadSuccess.Set(0)
+			time.Sleep(interval)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+
+		c, err := parseConfig(data, defaults, def)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error reloading config", "file", path, "err", err)
+			configReloadSuccess.Set(0)
+			continue
+		}
+		dropped, err := s.set(c)
+		for _, address := range dropped {
+			level.Warn(logger).Log("msg", "Dropped target added through the API, which the config now defines", "file", path, "target", address)
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "Error reloading config", "file", path, "err", err)
+			configReloadSuccess.Set(0)
+			continue
+		}
+		configReloadSuccess.Set(1)
+		configReloadSeconds.SetToCurrentTime()
+		level.Info(logger).Log("msg", "Reloaded config", "file", path)
+	}
+}
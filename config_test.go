@@ -0,0 +1,55 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigStorePersistFirst(t *testing.T) {
+	dir := t.TempDir()
+	store := newConfigStore(&Config{Modules: map[string]Module{defaultModule: {}}})
+	path := filepath.Join(dir, "targets.yml")
+	if err := store.loadTargets(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.addTarget(Target{Address: "olric-0:3320"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The targets file can no longer be replaced.
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := store.addTarget(Target{Address: "olric-1:3320"})
+	if !errors.As(err, new(persistError)) {
+		t.Errorf("addTarget() error = %v, want a persistError", err)
+	}
+	ok, err := store.removeTarget("olric-0:3320")
+	if !ok || !errors.As(err, new(persistError)) {
+		t.Errorf("removeTarget() = %v, %v, want true and a persistError", ok, err)
+	}
+	if targets := store.get().Targets; len(targets) != 1 || targets[0].Address != "olric-0:3320" {
+		t.Errorf("got targets %+v, want only olric-0:3320", targets)
+	}
+
+	err = store.addTarget(Target{Address: "olric-0:3320"})
+	if err == nil || errors.As(err, new(persistError)) {
+		t.Errorf("addTarget() of a duplicate error = %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	clientConnsOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "olric_exporter",
+		Name:      "client_connections_open",
+		Help:      "Number of connections opened by the client of a target, for the http and resp transports.",
+	}, []string{"target"})
+	clientConnsInUse = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "olric_exporter",
+		Name:      "client_connections_in_use",
+		Help:      "Number of requests of the client of a target in progress, each holding a connection, for all transports.",
+	}, []string{"target"})
+	clientConnWaits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "olric_exporter",
+		Name:      "client_connection_waits_total",
+		Help:      "Number of requests of the client of a target that found no idle connection, for the http transport.",
+	}, []string{"target"})
+	clientConnWaitSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "olric_exporter",
+		Name:      "client_connection_wait_seconds_total",
+		Help:      "Time requests of the client of a target waited for a connection, including dialing it, for the http transport.",
+	}, []string{"target"})
+)
+
+// trackedConn is a connection counted in clientConnsOpen until it is closed.
+type trackedConn struct {
+	net.Conn
+	open prometheus.Gauge
+	once sync.Once
+}
+
+func newTrackedConn(c net.Conn, target string) *trackedConn {
+	open := clientConnsOpen.WithLabelValues(target)
+	open.Inc()
+	return &trackedConn{Conn: c, open: open}
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.open.Dec)
+	return c.Conn.Close()
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var coordinatorChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: exporter.Namespace,
+	Subsystem: "cluster",
+	Name:      "coordinator_changes_total",
+	Help:      "Number of times the coordinator of the cluster changed between scrapes.",
+}, []string{"target"})
+
+// coordinators remembers the last coordinator seen for every target. The
+// exporters are created for every scrape, so it has to outlive them.
+var coordinators = &coordinatorTracker{last: make(map[string]string)}
+
+type coordinatorTracker struct {
+	mu   sync.Mutex
+	last map[string]string
+}
+
+// observe records the coordinator reported by the members of target and
+// counts a change if it differs from the previous one. The first member that
+// could be scraped is trusted, as all of them share the same view unless the
+// cluster is split.
+func (t *coordinatorTracker) observe(target string, results []exporter.MemberStats) {
+	var coordinator string
+	for _, ms := range results {
+		if ms.Err == nil && ms.Stats.ClusterCoordinator.Name != "" {
+			coordinator = ms.Stats.ClusterCoordinator.Name
+			break
+		}
+	}
+	if coordinator == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counter := coordinatorChanges.WithLabelValues(target)
+	if last, ok := t.last[target]; ok && last != coordinator {
+		counter.Inc()
+	}
+	t.last[target] = coordinator
+}
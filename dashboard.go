@@ -0,0 +1,181 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+)
+
+const (
+	panelWidth  = 12
+	panelHeight = 8
+)
+
+type gridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type panelTarget struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+	Format       string `json:"format,omitempty"`
+	Instant      bool   `json:"instant,omitempty"`
+	RefID        string `json:"refId"`
+}
+
+type panel struct {
+	ID          int           `json:"id"`
+	Type        string        `json:"type"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Datasource  string        `json:"datasource,omitempty"`
+	GridPos     gridPos       `json:"gridPos"`
+	Targets     []panelTarget `json:"targets,omitempty"`
+}
+
+type templateVar struct {
+	Name       string `json:"name"`
+	Label      string `json:"label"`
+	Type       string `json:"type"`
+	Query      string `json:"query"`
+	Datasource string `json:"datasource,omitempty"`
+	Multi      bool   `json:"multi,omitempty"`
+	IncludeAll bool   `json:"includeAll,omitempty"`
+	Refresh    int    `json:"refresh,omitempty"`
+}
+
+type dashboard struct {
+	Title         string   `json:"title"`
+	UID           string   `json:"uid,omitempty"`
+	Description   string   `json:"description"`
+	Tags          []string `json:"tags"`
+	SchemaVersion int      `json:"schemaVersion"`
+	Refresh       string   `json:"refresh"`
+	Time          struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	} `json:"time"`
+	Templating struct {
+		List []templateVar `json:"list"`
+	} `json:"templating"`
+	Panels []panel `json:"panels"`
+}
+
+// panelFor returns a panel showing the metric described by info. Counters are
+// shown as rates and info metrics as tables.
+func panelFor(info exporter.MetricInfo) panel {
+	selector := info.Name + `{instance=~"$instance"}`
+	legend := []string{"{{instance}}"}
+	for _, l := range info.Labels {
+		legend = append(legend, "{{"+l+"}}")
+	}
+
+	p := panel{
+		Type:        "timeseries",
+		Title:       info.Name,
+		Description: info.Help,
+		Datasource:  "$datasource",
+	}
+	switch {
+	case strings.HasSuffix(info.Name, "_info"):
+		p.Type = "table"
+		p.Targets = []panelTarget{{Expr: selector, Format: "table", Instant: true, RefID: "A"}}
+	case info.ValueType == prometheus.CounterValue:
+		p.Targets = []panelTarget{{
+			Expr:         "rate(" + selector + "[$__rate_interval])",
+			LegendFormat: strings.Join(legend, " "),
+			RefID:        "A",
+		}}
+	default:
+		p.Targets = []panelTarget{{Expr: selector, LegendFormat: strings.Join(legend, " "), RefID: "A"}}
+	}
+	return p
+}
+
+// generateDashboard returns a Grafana dashboard with a row per subsystem and a
+// panel per metric described by infos.
+func generateDashboard(title, uid string, infos []exporter.MetricInfo) dashboard {
+	d := dashboard{
+		Title:         title,
+		UID:           uid,
+		Description:   fmt.Sprintf("Generated by olric_exporter %s", version.Version),
+		Tags:          []string{"olric"},
+		SchemaVersion: 27,
+		Refresh:       "30s",
+	}
+	d.Time.From, d.Time.To = "now-1h", "now"
+	d.Templating.List = []templateVar{
+		{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		{
+			Name:       "instance",
+			Label:      "Instance",
+			Type:       "query",
+			Query:      "label_values(" + exporter.Namespace + "_member_up, instance)",
+			Datasource: "$datasource",
+			Multi:      true,
+			IncludeAll: true,
+			Refresh:    2,
+		},
+	}
+
+	id, y, col := 1, 0, 0
+	for i, info := range infos {
+		if i == 0 || info.Subsystem != infos[i-1].Subsystem {
+			if col != 0 {
+				y += panelHeight
+				col = 0
+			}
+			title := "Overview"
+			if info.Subsystem != "" {
+				title = strings.ToUpper(info.Subsystem[:1]) + info.Subsystem[1:]
+			}
+			d.Panels = append(d.Panels, panel{
+				ID:      id,
+				Type:    "row",
+				Title:   title,
+				GridPos: gridPos{X: 0, Y: y, W: 2 * panelWidth, H: 1},
+			})
+			id++
+			y++
+		}
+		p := panelFor(info)
+		p.ID = id
+		p.GridPos = gridPos{X: col * panelWidth, Y: y, W: panelWidth, H: panelHeight}
+		d.Panels = append(d.Panels, p)
+		id++
+		if col++; col == 2 {
+			y += panelHeight
+			col = 0
+		}
+	}
+	return d
+}
+
+// writeDashboard writes a Grafana dashboard for the metrics of e to w.
+func writeDashboard(w io.Writer, e *exporter.Exporter, title, uid string) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(generateDashboard(title, uid, e.MetricInfos()))
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/buraksezer/olric/stats"
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var unknownFields = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "olric_exporter",
+	Name:      "unknown_stats_fields_total",
+	Help:      "Number of decoded stats holding a field unknown to this exporter version, which is ignored. Map keys are shown as *.",
+}, []string{"field"})
+
+// decodedStats are the JSON stats of a member. The DMaps section is only
+// sent by Olric v0.5 and later.
+type decodedStats struct {
+	stats.Stats
+	DMaps *exporter.DMapCounters
+}
+
+// decodeStats decodes the JSON stats of a member, and its DMap command
+// counters if it reports them. Fields added by newer Olric versions are
+// ignored, and counted in unknownFields, so that it shows when upgrading the
+// exporter would yield more data.
+func decodeStats(data []byte) (stats.Stats, *exporter.DMapCounters, error) {
+	var s decodedStats
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s.Stats, nil, fmt.Errorf("error decoding stats: %w", err)
+	}
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return s.Stats, nil, fmt.Errorf("error decoding stats: %w", err)
+	}
+	found := make(map[string]bool)
+	findUnknown(raw, reflect.TypeOf(s), "", found)
+	for field := range found {
+		unknownFields.WithLabelValues(field).Inc()
+	}
+	return s.Stats, s.DMaps, nil
+}
+
+// findUnknown adds the paths of the fields of v that t does not have to
+// found. Fields are matched case-insensitively, like encoding/json does.
+func findUnknown(v interface{}, t reflect.Type, path string, found map[string]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, value := range obj {
+			f, ok := structField(t, key)
+			if !ok {
+				found[joinPath(path, key)] = true
+				continue
+			}
+			findUnknown(value, f.Type, joinPath(path, f.Name), found)
+		}
+	case reflect.Map:
+		if obj, ok := v.(map[string]interface{}); ok {
+			for _, value := range obj {
+				findUnknown(value, t.Elem(), joinPath(path, "*"), found)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if items, ok := v.([]interface{}); ok {
+			for _, item := range items {
+				findUnknown(item, t.Elem(), path, found)
+			}
+		}
+	}
+}
+
+// structField returns the exported field of t decoded from the JSON key,
+// including the fields of its embedded structs.
+func structField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if ef, ok := structField(f.Type, key); ok {
+				return ef, true
+			}
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
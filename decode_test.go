@@ -0,0 +1,67 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDecodeStats(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		data     string
+		counters *exporter.DMapCounters
+		unknown  string
+	}{
+		{
+			name: "v0.3",
+			data: `{"ReleaseVersion": "0.3.0", "ClusterCoordinator": {"Name": "a:3320"}}`,
+		},
+		{
+			name:     "v0.5",
+			data:     `{"ReleaseVersion": "0.5.0", "DMaps": {"GetHits": 8, "GetMisses": 2, "EvictedTotal": 1}}`,
+			counters: &exporter.DMapCounters{GetHits: 8, GetMisses: 2},
+			unknown:  "DMaps.EvictedTotal",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var before float64
+			if tc.unknown != "" {
+				before = testutil.ToFloat64(unknownFields.WithLabelValues(tc.unknown))
+			}
+			s, counters, err := decodeStats([]byte(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.ReleaseVersion == "" {
+				t.Error("ReleaseVersion not decoded")
+			}
+			switch {
+			case tc.counters == nil && counters != nil:
+				t.Errorf("got counters %+v, want none", *counters)
+			case tc.counters != nil && (counters == nil || *counters != *tc.counters):
+				t.Errorf("got counters %v, want %+v", counters, *tc.counters)
+			}
+			if tc.unknown != "" {
+				if got := testutil.ToFloat64(unknownFields.WithLabelValues(tc.unknown)); got != before+1 {
+					t.Errorf("unknown field %s counted %v times, want 1", tc.unknown, got-before)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+
+	"github.com/buraksezer/olric/stats"
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	demoMembers    = 3
+	demoPartitions = 271
+
+	// demoTableSize is the size of a storage table, a DMap gets a new table
+	// whenever its tables are full.
+	demoTableSize = 1 << 20
+)
+
+var demoDMaps = []string{"users", "sessions", "carts"}
+
+// demoCluster generates the statistics of a fictitious cluster whose DMaps
+// grow and shrink randomly between collections. Every partition has one
+// backup on the next member.
+type demoCluster struct {
+	mu      sync.Mutex
+	rand    *rand.Rand
+	members []string
+	lengths map[string][]int
+	garbage map[string][]int
+	numGC   uint32
+}
+
+func newDemoCluster() *demoCluster {
+	d := &demoCluster{
+		rand:    rand.New(rand.NewSource(rand.Int63())),
+		lengths: make(map[string][]int),
+		garbage: make(map[string][]int),
+	}
+	for i := 0; i < demoMembers; i++ {
+		d.members = append(d.members, fmt.Sprintf("olric-%d:3320", i))
+	}
+	for _, name := range demoDMaps {
+		d.lengths[name] = make([]int, demoPartitions)
+		d.garbage[name] = make([]int, demoPartitions)
+		for partID := range d.lengths[name] {
+			d.lengths[name][partID] = d.rand.Intn(2000)
+		}
+	}
+	return d
+}
+
+// stats implements exporter.StatsFunc.
+func (d *demoCluster) stats() []exporter.MemberStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.numGC += uint32(d.rand.Intn(5))
+	for _, name := range demoDMaps {
+		for partID, n := range d.lengths[name] {
+			delta := d.rand.Intn(41) - 20
+			if delta < 0 {
+				d.garbage[name][partID] += -delta * 256
+			}
+			// The storage is compacted once half of it is garbage.
+			if d.garbage[name][partID] > n*256 {
+				d.garbage[name][partID] = 0
+			}
+			if n += delta; n < 0 {
+				n = 0
+			}
+			d.lengths[name][partID] = n
+		}
+	}
+
+	results := make([]exporter.MemberStats, 0, len(d.members))
+	for i, member := range d.members {
+		s := stats.Stats{
+			Cmdline:        []string{"olricd", "-c", "olricd.yaml"},
+			ReleaseVersion: "0.3.0-beta.5",
+			Runtime: stats.Runtime{
+				GOOS:         "linux",
+				GOARCH:       "amd64",
+				Version:      "go1.15.2",
+				NumCPU:       8,
+				NumGoroutine: 40 + d.rand.Intn(20),
+			},
+			Partitions: make(map[uint64]stats.Partition, demoPartitions),
+			Backups:    make(map[uint64]stats.Partition, demoPartitions),
+		}
+		s.ClusterCoordinator.Name = d.members[0]
+		s.ClusterCoordinator.ID = 1
+		s.ClusterCoordinator.Birthdate = 1600000000000000000
+		coordinator := s.ClusterCoordinator
+
+		var inuse uint64
+		for partID := uint64(0); partID < demoPartitions; partID++ {
+			ownerIdx := int(partID) % len(d.members)
+			owner, backup := coordinator, coordinator
+			owner.Name, owner.ID = d.members[ownerIdx], uint64(ownerIdx+1)
+			backupIdx := (ownerIdx + 1) % len(d.members)
+			backup.Name, backup.ID = d.members[backupIdx], uint64(backupIdx+1)
+
+			primary := stats.Partition{Owner: owner, DMaps: make(map[string]stats.DMap)}
+			primary.Backups = append(primary.Backups, backup)
+			replica := stats.Partition{DMaps: make(map[string]stats.DMap)}
+			replica.Backups = append(replica.Backups, backup)
+			for _, name := range demoDMaps {
+				n := d.lengths[name][int(partID)]
+				switch i {
+				case ownerIdx:
+					primary.Length += n
+					primary.DMaps[name] = d.dmap(name, int(partID), n)
+				case backupIdx:
+					// Backups are written asynchronously and lag a little.
+					if d.rand.Intn(10) == 0 && n > 0 {
+						n--
+					}
+					replica.Length += n
+					replica.DMaps[name] = d.dmap(name, int(partID), n)
+				default:
+					continue
+				}
+				inuse += uint64(n) * 256
+			}
+			s.Partitions[partID] = primary
+			s.Backups[partID] = replica
+		}
+		s.Runtime.MemStats.Alloc = inuse + uint64(d.rand.Intn(64<<20))
+		s.Runtime.MemStats.HeapInuse = s.Runtime.MemStats.Alloc + 8<<20
+		s.Runtime.MemStats.Sys = s.Runtime.MemStats.HeapInuse + 64<<20
+		s.Runtime.MemStats.NumGC = d.numGC
+		results = append(results, exporter.MemberStats{Member: member, Stats: s})
+	}
+	return results
+}
+
+// dmap returns the statistics of the fragment of a DMap with n keys.
+func (d *demoCluster) dmap(name string, partID, n int) stats.DMap {
+	inuse := n * 256
+	garbage := d.garbage[name][partID]
+	tables := (inuse+garbage)/demoTableSize + 1
+	return stats.DMap{
+		Name:      name,
+		Length:    n,
+		NumTables: tables,
+		SlabInfo: stats.SlabInfo{
+			Allocated: tables * demoTableSize,
+			Inuse:     inuse,
+			Garbage:   garbage,
+		},
+	}
+}
+
+// demoHandler returns a handler that serves the metrics of a fictitious
+// cluster, see demoCluster, along the ones gathered by g.
+func demoHandler(module Module, g prometheus.Gatherer, logger log.Logger) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter.New(newDemoCluster().stats, module.Collectors, logger, module.options()...))
+	g = prometheus.Gatherers{g, registry}
+	return promhttp.HandlerFor(g, promhttp.HandlerOpts{DisableCompression: true})
+}
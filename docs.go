@@ -0,0 +1,128 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricDoc documents a metric served by the exporter.
+type metricDoc struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Help   string   `json:"help"`
+	Labels []string `json:"labels"`
+}
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<html>
+<head><title>Olric Exporter Metrics</title></head>
+<body>
+<h1>Olric Exporter Metrics</h1>
+<table border="1" cellpadding="4">
+<tr><th>Name</th><th>Type</th><th>Labels</th><th>Help</th></tr>
+{{range .}}<tr><td><code>{{.Name}}</code></td><td>{{.Type}}</td><td>{{range $i, $l := .Labels}}{{if $i}}, {{end}}<code>{{$l}}</code>{{end}}</td><td>{{.Help}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// selfMetricDocs documents the metrics of the exporter itself. They are
+// listed here rather than gathered, since the labelled ones have no series
+// until a target reports them, and some are only registered by the flags
+// that need them.
+var selfMetricDocs = []metricDoc{
+	{Name: "olric_cluster_coordinator_changes_total", Type: "counter", Labels: []string{"target"},
+		Help: "Number of times the coordinator of the cluster changed between scrapes."},
+	{Name: "olric_dmap_entry_size_bytes", Type: "histogram", Labels: []string{"dmap"},
+		Help: "Distribution of the encoded sizes of a sample of the values of a DMap."},
+	{Name: "olric_exporter_client_backoff_seconds", Type: "gauge", Labels: []string{"target"},
+		Help: "Delay before the next connection attempt to a target that could not be reached, 0 if it is connected or can be reconnected."},
+	{Name: "olric_exporter_client_connection_wait_seconds_total", Type: "counter", Labels: []string{"target"},
+		Help: "Time requests of the client of a target waited for a connection, including dialing it, for the http transport."},
+	{Name: "olric_exporter_client_connection_waits_total", Type: "counter", Labels: []string{"target"},
+		Help: "Number of requests of the client of a target that found no idle connection, for the http transport."},
+	{Name: "olric_exporter_client_connections_in_use", Type: "gauge", Labels: []string{"target"},
+		Help: "Number of requests of the client of a target in progress, each holding a connection, for all transports."},
+	{Name: "olric_exporter_client_connections_open", Type: "gauge", Labels: []string{"target"},
+		Help: "Number of connections opened by the client of a target, for the http and resp transports."},
+	{Name: "olric_exporter_client_health_check_failures_total", Type: "counter", Labels: []string{"target"},
+		Help: "Number of failed pings of the connections to a target between scrapes."},
+	{Name: "olric_exporter_client_restarts_total", Type: "counter", Labels: []string{"target"},
+		Help: "Number of times the client of a target was rebuilt by the watchdog after consecutive scrapes with failed members."},
+	{Name: "olric_exporter_config_last_reload_success_timestamp_seconds", Type: "gauge",
+		Help: "Timestamp of the last successful configuration reload."},
+	{Name: "olric_exporter_config_last_reload_successful", Type: "gauge",
+		Help: "Whether the last configuration reload attempt was successful."},
+	{Name: "olric_exporter_ha_active", Type: "gauge",
+		Help: "Whether this exporter replica collects the metrics of the targets, because it holds the lock or the lock cannot be reached."},
+	{Name: samplesCollectedName, Type: "gauge", Labels: []string{"target"}, Help: samplesCollectedHelp},
+	{Name: "olric_exporter_scrape_errors_total", Type: "counter", Labels: []string{"target"},
+		Help: "Number of members whose collection failed, including timeouts."},
+	{Name: "olric_exporter_scrape_timeouts_total", Type: "counter", Labels: []string{"target"},
+		Help: "Number of members whose collection hit the collection timeout."},
+	{Name: seriesTruncatedName, Type: "gauge", Help: seriesTruncatedHelp},
+	{Name: "olric_exporter_stats_duration_seconds", Type: "histogram", Labels: []string{"target"},
+		Help: "Duration of the stats requests to the members of a target."},
+	{Name: "olric_exporter_unknown_stats_fields_total", Type: "counter", Labels: []string{"field"},
+		Help: "Number of decoded stats holding a field unknown to this exporter version, which is ignored. Map keys are shown as *."},
+}
+
+// metricDocs lists the Olric metrics of the default module, the target info
+// and the metrics of the exporter itself. Metrics of registered collectors
+// are unknown until collected, and those of the targets' labels and metric
+// rules are not shown.
+func metricDocs(module Module, logger log.Logger) []metricDoc {
+	var docs []metricDoc
+	for _, info := range newExporter(context.Background(), Target{}, module, logger).MetricInfos() {
+		kind := "gauge"
+		if info.ValueType == prometheus.CounterValue {
+			kind = "counter"
+		}
+		docs = append(docs, metricDoc{Name: info.Name, Type: kind, Help: info.Help, Labels: info.Labels})
+	}
+	docs = append(docs, metricDoc{
+		Name:   targetInfoName,
+		Type:   "gauge",
+		Help:   targetInfoHelp,
+		Labels: targetInfoLabels,
+	})
+	docs = append(docs, selfMetricDocs...)
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+	return docs
+}
+
+// docsHandler serves the documentation of the metrics as HTML, or as JSON
+// with the format=json URL parameter or an Accept header asking for JSON.
+func docsHandler(store *configStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docs := metricDocs(store.get().Modules[defaultModule], logger)
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			writeJSON(w, logger, docs)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := docsTemplate.Execute(w, docs); err != nil {
+			level.Error(logger).Log("msg", "Failed to write metrics documentation", "err", err)
+		}
+	}
+}
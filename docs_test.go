@@ -0,0 +1,74 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var descPattern = regexp.MustCompile(`^Desc{fqName: "(.*)", help: "(.*)", constLabels: {.*}, variableLabels: \[(.*)\]}$`)
+
+// TestSelfMetricDocs checks selfMetricDocs against the descriptors of the
+// metrics of the exporter.
+func TestSelfMetricDocs(t *testing.T) {
+	docs := make(map[string]metricDoc)
+	for _, doc := range selfMetricDocs {
+		docs[doc.Name] = doc
+	}
+	ch := make(chan *prometheus.Desc, 64)
+	for _, c := range []prometheus.Collector{
+		scrapeErrors, scrapeTimeouts, coordinatorChanges, unknownFields,
+		backoffCollector{p: clients}, healthCheckFailures, clientRestarts,
+		clientConnsOpen, clientConnsInUse, clientConnWaits, clientConnWaitSeconds,
+		statsDuration, configReloadSuccess, configReloadSeconds, haActive,
+	} {
+		c.Describe(ch)
+	}
+	ch <- entrySizeDesc
+	ch <- newSampleCounter(nil, "", nil).desc
+	close(ch)
+
+	for desc := range ch {
+		m := descPattern.FindStringSubmatch(desc.String())
+		if m == nil {
+			t.Fatalf("unexpected descriptor %s", desc)
+		}
+		doc, ok := docs[m[1]]
+		if !ok {
+			t.Errorf("%s is not documented", m[1])
+			continue
+		}
+		if doc.Help != m[2] {
+			t.Errorf("%s is documented with %q, want %q", m[1], doc.Help, m[2])
+		}
+		var labels []string
+		if m[3] != "" {
+			labels = strings.Fields(m[3])
+		}
+		if !reflect.DeepEqual(doc.Labels, labels) {
+			t.Errorf("%s is documented with the labels %v, want %v", m[1], doc.Labels, labels)
+		}
+		delete(docs, m[1])
+	}
+	delete(docs, seriesTruncatedName)
+	for name := range docs {
+		t.Errorf("%s is documented but not exported", name)
+	}
+}
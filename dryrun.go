@@ -0,0 +1,88 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// dryRun writes the effective configuration and the targets of s to w,
+// including the ones added at runtime. The host of every target is resolved,
+// so typos and DNS problems surface before the exporter is deployed.
+func dryRun(w io.Writer, s *configStore) error {
+	c := s.get()
+	added := make(map[string]bool)
+	for _, t := range s.runtimeTargets() {
+		added[t.Address] = true
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "# Effective configuration")
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "# Targets")
+	var failed int
+	for _, t := range c.Targets {
+		target := t.Address
+		if added[target] {
+			fmt.Fprintf(w, "# %s was added through the API\n", target)
+		}
+		if isSRV(target) {
+			targets, err := lookupSRV(context.Background(), target)
+			if err != nil {
+				fmt.Fprintf(w, "- %s  # cannot be resolved: %v\n", target, err)
+				failed++
+				continue
+			}
+			fmt.Fprintf(w, "- %s  # %s\n", target, strings.Join(targets, ", "))
+			continue
+		}
+		hostport := target
+		if u, err := url.Parse(target); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			hostport = u.Host
+			if u.Port() == "" {
+				hostport = net.JoinHostPort(u.Hostname(), u.Scheme)
+			}
+		}
+		host, _, err := net.SplitHostPort(hostport)
+		if err != nil {
+			fmt.Fprintf(w, "- %s  # invalid address: %v\n", target, err)
+			failed++
+			continue
+		}
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			fmt.Fprintf(w, "- %s  # cannot be resolved: %v\n", target, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "- %s  # %s\n", target, strings.Join(addrs, ", "))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d targets are invalid", failed, len(c.Targets))
+	}
+	return nil
+}
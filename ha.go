@@ -0,0 +1,182 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buraksezer/olric"
+	"github.com/buraksezer/olric/client"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var haActive = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "olric_exporter",
+	Name:      "ha_active",
+	Help:      "Whether this exporter replica collects the metrics of the targets, because it holds the lock or the lock cannot be reached.",
+})
+
+// haElector elects the active one of several exporter replicas with a lock
+// in an Olric DMap. Only the active replica collects the metrics of the
+// targets, the standby replicas serve the metrics they collected the last
+// time they were active, for up to a lease. The lock lives on the monitored
+// cluster, so if it cannot be reached all replicas collect, in order to
+// report the outage rather than hide it behind cached metrics.
+type haElector struct {
+	dm    *client.DMap
+	key   string
+	lease time.Duration
+
+	mu          sync.Mutex
+	lock        *client.LockContext
+	unreachable bool
+	cached      []byte
+	cachedAt    time.Time
+}
+
+// newHAElector returns an elector locking key in dmap of the cluster of t.
+// Its gauge is registered with reg.
+func newHAElector(t Target, module Module, dmap, key string, lease time.Duration, reg prometheus.Registerer) (*haElector, error) {
+	if module.Transport != transportOlric {
+		return nil, fmt.Errorf("the lock needs the %s transport", transportOlric)
+	}
+	// The client keeps its addresses, so SRV names are only looked up once.
+	addrs, err := expandSRV(append([]string{t.Address}, t.Seeds...), module.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newOlricClient(&client.Config{
+		Addrs:       addrs,
+		MaxConn:     1,
+		Serializer:  serializers[module.Serializer](),
+		DialTimeout: module.dialTimeout(),
+		KeepAlive:   module.KeepAlive,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Olric: %w", err)
+	}
+	// The gauge is only exposed if the election is enabled.
+	reg.MustRegister(haActive)
+	return &haElector{dm: c.NewDMap(dmap), key: key, lease: lease}, nil
+}
+
+// active reports whether the replica collects the metrics, i.e. it holds the
+// lock or the lock cannot be reached.
+func (e *haElector) active() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lock != nil || e.unreachable
+}
+
+// run acquires the lock and renews it every half lease. Olric locks cannot
+// be extended, so the lock is renewed by releasing and acquiring it again.
+// The standby replicas try once per half lease, which makes it unlikely that
+// they take the lock in between.
+func (e *haElector) run(logger log.Logger) {
+	for {
+		e.mu.Lock()
+		lock := e.lock
+		e.mu.Unlock()
+		if lock != nil {
+			if err := lock.Unlock(); err != nil && !errors.Is(err, olric.ErrNoSuchLock) {
+				level.Warn(logger).Log("msg", "Error releasing the HA lock", "key", e.key, "err", err)
+			}
+		}
+		next, err := e.dm.LockWithTimeout(e.key, e.lease, time.Millisecond)
+		unreachable := err != nil && !errors.Is(err, olric.ErrLockNotAcquired)
+		if unreachable {
+			level.Warn(logger).Log("msg", "Error acquiring the HA lock, collecting metrics", "key", e.key, "err", err)
+		}
+		switch {
+		case next != nil && lock == nil:
+			level.Info(logger).Log("msg", "Acquired the HA lock, collecting metrics", "key", e.key)
+		case next == nil && lock != nil && !unreachable:
+			level.Info(logger).Log("msg", "Lost the HA lock, serving cached metrics", "key", e.key)
+		}
+		e.mu.Lock()
+		e.lock = next
+		e.unreachable = unreachable
+		e.mu.Unlock()
+		if next != nil || unreachable {
+			haActive.Set(1)
+		} else {
+			haActive.Set(0)
+		}
+		time.Sleep(e.lease / 2)
+	}
+}
+
+// record returns a gatherer that caches the metrics gathered from g, to be
+// served while the replica is standby.
+func (e *haElector) record(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		if err != nil {
+			return families, err
+		}
+		var buf bytes.Buffer
+		enc := expfmt.NewEncoder(&buf, expfmt.FmtText)
+		for _, mf := range families {
+			if err := enc.Encode(mf); err != nil {
+				return nil, err
+			}
+		}
+		e.mu.Lock()
+		e.cached, e.cachedAt = buf.Bytes(), time.Now()
+		e.mu.Unlock()
+		return families, nil
+	})
+}
+
+// reset drops the cached metrics. They are cached for all targets at once,
+// so they are dropped whole.
+func (e *haElector) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cached, e.cachedAt = nil, time.Time{}
+}
+
+// Gather implements prometheus.Gatherer and returns the cached metrics, or
+// none once they are older than a lease, by when the active replica has
+// collected fresher ones. They are kept encoded, since the metric rules
+// modify the gathered families.
+func (e *haElector) Gather() ([]*dto.MetricFamily, error) {
+	e.mu.Lock()
+	cached := e.cached
+	if time.Since(e.cachedAt) > e.lease {
+		cached = nil
+	}
+	e.mu.Unlock()
+	var p expfmt.TextParser
+	byName, err := p.TextToMetricFamilies(bytes.NewReader(cached))
+	if err != nil {
+		return nil, err
+	}
+	families := make([]*dto.MetricFamily, 0, len(byName))
+	for _, mf := range byName {
+		families = append(families, mf)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].GetName() < families[j].GetName() })
+	return families, nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+)
+
+// Verdicts of the cluster health.
+const (
+	healthHealthy  = "healthy"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+	healthUnknown  = "unknown"
+)
+
+// memberHealth is the state of a member in the last collection.
+type memberHealth struct {
+	Member string `json:"member"`
+	Up     bool   `json:"up"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// clusterHealth is the verdict on the cluster of a target: healthy if all of
+// its members could be scraped, degraded if some of them could, and down if
+// none could.
+type clusterHealth struct {
+	Target      string         `json:"target"`
+	Status      string         `json:"status"`
+	Coordinator string         `json:"coordinator,omitempty"`
+	MembersUp   int            `json:"members_up"`
+	Members     []memberHealth `json:"members"`
+	CollectedAt time.Time      `json:"collected_at"`
+}
+
+// health remembers the outcome of the last collection of every target, like
+// coordinators.
+var health = &healthTracker{last: make(map[string]clusterHealth)}
+
+type healthTracker struct {
+	mu   sync.Mutex
+	last map[string]clusterHealth
+}
+
+// observe records the health of the cluster of target from the results of a
+// collection.
+func (t *healthTracker) observe(target string, results []exporter.MemberStats) {
+	h := clusterHealth{Target: target, CollectedAt: time.Now(), Members: make([]memberHealth, 0, len(results))}
+	for _, ms := range results {
+		m := memberHealth{Member: ms.Member, Up: ms.Err == nil}
+		if ms.Err != nil {
+			m.Reason, m.Error = exporter.ErrorReason(ms.Err), ms.Err.Error()
+		} else {
+			h.MembersUp++
+			if h.Coordinator == "" {
+				h.Coordinator = ms.Stats.ClusterCoordinator.Name
+			}
+		}
+		h.Members = append(h.Members, m)
+	}
+	sort.Slice(h.Members, func(i, j int) bool { return h.Members[i].Member < h.Members[j].Member })
+	switch {
+	case h.MembersUp == 0:
+		h.Status = healthDown
+	case h.MembersUp < len(h.Members):
+		h.Status = healthDegraded
+	default:
+		h.Status = healthHealthy
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last[target] = h
+}
+
+// get returns the last health recorded for target.
+func (t *healthTracker) get(target string) (clusterHealth, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.last[target]
+	return h, ok
+}
+
+// reset forgets the health of target, or of all targets if target is empty,
+// so that it is collected again when asked for.
+func (t *healthTracker) reset(target string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if target == "" {
+		t.last = make(map[string]clusterHealth)
+		return
+	}
+	delete(t.last, target)
+}
+
+// clusterHealthHandler serves the health of the cluster of the target given
+// in the target URL parameter as JSON, or of the first target if none is
+// given. It is computed from the last collection of the target, which is
+// made first if the target has not been collected within maxAge plus the
+// timeout of its module, e.g. on a standby replica. Down clusters are served
+// with 503 Service Unavailable, so that load balancers can check them without
+// parsing the body.
+func clusterHealthHandler(store *configStore, maxAge time.Duration, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := store.get()
+		t, ok := c.Targets[0], true
+		if address := r.URL.Query().Get("target"); address != "" {
+			t, ok = c.target(address)
+		}
+		if !ok {
+			http.Error(w, "Unknown target", http.StatusNotFound)
+			return
+		}
+		module := c.Modules[t.Module]
+		h, ok := health.get(t.Address)
+		if !ok || time.Since(h.CollectedAt) > maxAge+module.Timeout {
+			fetchTarget(context.Background(), t, module)
+			h, ok = health.get(t.Address)
+		}
+		if !ok || time.Since(h.CollectedAt) > maxAge+module.Timeout {
+			h = clusterHealth{Target: t.Address, Status: healthUnknown, Members: []memberHealth{}, CollectedAt: h.CollectedAt}
+		}
+		if h.Status == healthDown || h.Status == healthUnknown {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writeJSON(w, logger, h)
+	}
+}
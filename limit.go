@@ -0,0 +1,97 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const seriesTruncatedName, seriesTruncatedHelp = "olric_exporter_series_truncated",
+	"Number of series left out of the scrape to stay below --metrics.max-series."
+
+// limitGatherer caps the number of series gathered from g at max. The
+// families are truncated to the same number of series, as large as the cap
+// allows, so the small families are kept whole and the series of the large
+// ones, e.g. the partitions, are cut. The series of a family are sorted by
+// their labels, so the same series are kept on every scrape.
+type limitGatherer struct {
+	g      prometheus.Gatherer
+	max    int
+	logger log.Logger
+}
+
+func (lg limitGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := lg.g.Gather()
+	if err != nil {
+		return families, err
+	}
+	sizes := make([]int, 0, len(families))
+	var total int
+	for _, mf := range families {
+		sizes = append(sizes, len(mf.Metric))
+		total += len(mf.Metric)
+	}
+
+	// The series truncated metric counts against the cap.
+	budget := lg.max - 1
+	var truncated int
+	if total > budget {
+		perFamily := familyLimit(sizes, budget)
+		for _, mf := range families {
+			if len(mf.Metric) > perFamily {
+				truncated += len(mf.Metric) - perFamily
+				mf.Metric = mf.Metric[:perFamily]
+			}
+		}
+		level.Warn(lg.logger).Log("msg", "Too many series, truncating", "series", total, "max", lg.max, "truncated", truncated)
+	}
+
+	name, help := seriesTruncatedName, seriesTruncatedHelp
+	kind := dto.MetricType_GAUGE
+	value := float64(truncated)
+	families = append(families, &dto.MetricFamily{
+		Name:   &name,
+		Help:   &help,
+		Type:   &kind,
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: &value}}},
+	})
+	sort.Slice(families, func(i, j int) bool { return families[i].GetName() < families[j].GetName() })
+	return families, nil
+}
+
+// familyLimit returns the largest number of series per family such that the
+// truncated families hold at most budget series.
+func familyLimit(sizes []int, budget int) int {
+	sorted := append([]int(nil), sizes...)
+	sort.Ints(sorted)
+	// The families smaller than the limit are kept whole, the others share
+	// the rest of the budget.
+	for i, n := range sorted {
+		remaining := len(sorted) - i
+		if n*remaining > budget {
+			if budget < 0 {
+				return 0
+			}
+			return budget / remaining
+		}
+		budget -= n
+	}
+	return budget
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestFamilyLimit(t *testing.T) {
+	tests := []struct {
+		sizes  []int
+		budget int
+		want   int
+	}{
+		{sizes: []int{1, 1, 1}, budget: 10, want: 7},
+		{sizes: []int{1, 2, 100}, budget: 10, want: 7},
+		{sizes: []int{1, 50, 100}, budget: 11, want: 5},
+		{sizes: []int{5, 5}, budget: 4, want: 2},
+		{sizes: []int{5, 5, 5}, budget: 2, want: 0},
+		{sizes: []int{5}, budget: -1, want: 0},
+	}
+	for _, tt := range tests {
+		if got := familyLimit(tt.sizes, tt.budget); got != tt.want {
+			t.Errorf("familyLimit(%v, %d) = %d, want %d", tt.sizes, tt.budget, got, tt.want)
+		}
+	}
+}
+
+func TestLimitGatherer(t *testing.T) {
+	tests := []struct {
+		name      string
+		max       int
+		want      map[string]int
+		truncated float64
+	}{
+		{
+			name: "below the limit",
+			max:  100,
+			want: map[string]int{"olric_partition_length": 50, "olric_member_up": 3, "olric_dmap_length": 10},
+		},
+		{
+			name:      "large families are cut",
+			max:       20,
+			want:      map[string]int{"olric_partition_length": 8, "olric_member_up": 3, "olric_dmap_length": 8},
+			truncated: 44,
+		},
+		{
+			name:      "more families than the limit",
+			max:       2,
+			want:      map[string]int{"olric_partition_length": 0, "olric_member_up": 0, "olric_dmap_length": 0},
+			truncated: 63,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := limitGatherer{g: testGatherer(
+				testFamily("olric_partition_length", dto.MetricType_GAUGE, 50),
+				testFamily("olric_member_up", dto.MetricType_GAUGE, 3),
+				testFamily("olric_dmap_length", dto.MetricType_GAUGE, 10),
+			), max: tt.max, logger: log.NewNopLogger()}
+			families, err := g.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var total int
+			for _, mf := range families {
+				if mf.GetName() == "olric_exporter_series_truncated" {
+					if v := mf.Metric[0].GetGauge().GetValue(); v != tt.truncated {
+						t.Errorf("olric_exporter_series_truncated = %v, want %v", v, tt.truncated)
+					}
+					continue
+				}
+				total += len(mf.Metric)
+				if n := len(mf.Metric); n != tt.want[mf.GetName()] {
+					t.Errorf("%s has %d series, want %d", mf.GetName(), n, tt.want[mf.GetName()])
+				}
+			}
+			if total+1 > tt.max && tt.truncated > 0 {
+				t.Errorf("got %d series, more than %d", total+1, tt.max)
+			}
+		})
+	}
+}
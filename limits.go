@@ -0,0 +1,114 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// cgroupRoot is where the cgroups are mounted. In a container with its own
+// cgroup namespace, the limits of the container are at its root.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// readCgroup returns the trimmed content of the file of a cgroup, or the
+// empty string if it cannot be read.
+func readCgroup(path ...string) string {
+	data, err := ioutil.ReadFile(filepath.Join(append([]string{cgroupRoot}, path...)...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// cpuQuota returns the number of CPUs the cgroup of the exporter may use, as
+// given by its cgroup v2 cpu.max or cgroup v1 CFS quota, or 0 if it is not
+// limited.
+func cpuQuota() float64 {
+	var quota, period string
+	if fields := strings.Fields(readCgroup("cpu.max")); len(fields) == 2 {
+		quota, period = fields[0], fields[1]
+	} else {
+		quota, period = readCgroup("cpu", "cpu.cfs_quota_us"), readCgroup("cpu", "cpu.cfs_period_us")
+	}
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
+
+// memoryLimit returns the memory limit of the cgroup of the exporter in
+// bytes, as given by its cgroup v2 memory.max or cgroup v1
+// memory.limit_in_bytes, or 0 if it is not limited.
+func memoryLimit() int64 {
+	limit := readCgroup("memory.max")
+	if limit == "" {
+		limit = readCgroup("memory", "memory.limit_in_bytes")
+	}
+	n, err := strconv.ParseInt(limit, 10, 64)
+	// cgroup v1 reports no limit as the largest page aligned value.
+	if err != nil || n <= 0 || n >= math.MaxInt64/2 {
+		return 0
+	}
+	return n
+}
+
+// setLimits sets GOMAXPROCS and the soft memory limit of the Go runtime, so
+// that a container with a CPU quota is not throttled by more threads than
+// it may run, and the garbage collector runs before its memory limit is
+// reached. If procs or memLimit are not positive, they are derived from the
+// limits of the cgroup, the memory limit being ratio of the limit of the
+// cgroup. The GOMAXPROCS and GOMEMLIMIT environment variables take
+// precedence over the limits of the cgroup.
+func setLimits(procs int, memLimit int64, ratio float64, logger log.Logger) {
+	if procs <= 0 && os.Getenv("GOMAXPROCS") == "" {
+		if quota := cpuQuota(); quota > 0 {
+			procs = int(math.Max(1, math.Floor(quota)))
+			if procs > runtime.NumCPU() {
+				procs = runtime.NumCPU()
+			}
+		}
+	}
+	if procs > 0 {
+		runtime.GOMAXPROCS(procs)
+		level.Info(logger).Log("msg", "Set GOMAXPROCS", "procs", procs)
+	}
+
+	if memLimit <= 0 && os.Getenv("GOMEMLIMIT") == "" {
+		if limit := memoryLimit(); limit > 0 {
+			memLimit = int64(float64(limit) * ratio)
+		}
+	}
+	if memLimit > 0 {
+		if !setMemoryLimit(memLimit) {
+			level.Warn(logger).Log("msg", "The memory limit needs Go 1.19 or later", "limit", memLimit)
+			return
+		}
+		level.Info(logger).Log("msg", "Set the memory limit of the Go runtime", "bytes", memLimit)
+	}
+}
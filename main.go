@@ -15,12 +15,17 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/buraksezer/olric/client"
-	"github.com/buraksezer/olric/serializer"
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
@@ -31,92 +36,593 @@ import (
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
-type Exporter struct {
-	address string
-	timeout time.Duration
-	logger  log.Logger
-
-	up *prometheus.Desc
+// timeoutParam returns the duration given in the timeout URL parameter of r,
+// or def if the parameter is not set.
+func timeoutParam(r *http.Request, def time.Duration) (time.Duration, error) {
+	v := r.URL.Query().Get("timeout")
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout parameter %q", v)
+	}
+	return d, nil
 }
 
-// NewExporter returns an initialized exporter.
-func NewExporter(server string, timeout time.Duration, logger log.Logger) *Exporter {
-	return &Exporter{
-		address: server,
-		timeout: timeout,
-		logger:  logger,
-		up: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "", "up"),
-			"Could the Olric server be reached.",
-			nil,
-			nil,
-		),
+// partitionParam returns the filter of the partitions given in the
+// partition URL parameter of r as a comma separated list of partition IDs
+// and ranges, e.g. 42 or 0-9,42. It returns nil if the parameter is not set.
+func partitionParam(r *http.Request) (func(uint64) bool, error) {
+	v := r.URL.Query().Get("partition")
+	if v == "" {
+		return nil, nil
 	}
+	type span struct{ from, to uint64 }
+	var spans []span
+	for _, part := range strings.Split(v, ",") {
+		from, to := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			from, to = part[:i], part[i+1:]
+		}
+		f, err1 := strconv.ParseUint(strings.TrimSpace(from), 10, 64)
+		t, err2 := strconv.ParseUint(strings.TrimSpace(to), 10, 64)
+		if err1 != nil || err2 != nil || f > t {
+			return nil, fmt.Errorf("invalid partition parameter %q", v)
+		}
+		spans = append(spans, span{from: f, to: t})
+	}
+	return func(partID uint64) bool {
+		for _, s := range spans {
+			if partID >= s.from && partID <= s.to {
+				return true
+			}
+		}
+		return false
+	}, nil
 }
 
-// Collect fetches the statistics from the configured Olric server, and
-// delivers them as Prometheus metrics. It implements prometheus.Collector.
-func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	cc := &client.Config{
-		Addrs:       []string{e.address},
-		MaxConn:     10,
-		Serializer:  serializer.NewMsgpackSerializer(),
-		DialTimeout: e.timeout,
-	}
-	c, err := client.New(cc)
+// scrapeTarget is a target along with the labels attached to its metrics.
+type scrapeTarget struct {
+	Target
+	labels prometheus.Labels
+}
+
+// serveOptions are the settings of serveTargets that do not depend on the
+// configuration.
+type serveOptions struct {
+	// ha elects the replica collecting the metrics if it is not nil.
+	ha *haElector
+
+	// maxSeries caps the number of series of a scrape if it is positive.
+	maxSeries int
+
+	// shard selects the configured targets scraped on /metrics.
+	shard shard
+
+	// gatherer gathers the metrics of the exporter itself, which are served
+	// on /metrics.
+	gatherer prometheus.Gatherer
+
+	// summary is the ratio of the collections whose summary is logged.
+	summary float64
+
+	// exemplars attaches the trace ID of the scrape to the latency metrics,
+	// which are then exposed in the OpenMetrics format.
+	exemplars bool
+}
+
+// serveTargets collects the metrics of targets and writes them to w, along
+// with the metrics of gatherers. If there is an HA election, the targets are
+// only collected while the replica is active, and served from its cache
+// otherwise.
+// The partition metrics can be restricted with the partition URL parameter,
+// see partitionParam.
+func serveTargets(w http.ResponseWriter, r *http.Request, c *Config, targets []scrapeTarget, opts serveOptions, logger log.Logger, gatherers ...prometheus.Gatherer) {
+	keep, err := partitionParam(r)
 	if err != nil {
-		ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, 0)
-		level.Error(e.logger).Log("msg", "Failed to connect to Olric", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	var exporterOpts []exporter.Option
+	if keep != nil {
+		exporterOpts = append(exporterOpts, exporter.WithPartitions(keep))
+	}
+	if opts.summary > 0 {
+		exporterOpts = append(exporterOpts, exporter.WithSummary(opts.summary))
+	}
+	ha := opts.ha
+	ctx := context.Background()
+	// The lines not about a single target, e.g. of the series limit, still
+	// carry the scrape ID, and the target of a probe.
+	scrapeLogger := log.With(logger, "scrape_id", scrapeID(r.Context()))
+	if len(targets) == 1 {
+		scrapeLogger = log.With(scrapeLogger, "target", targets[0].Address, "module", targets[0].Module)
+	}
+	if opts.exemplars {
+		ctx = withTraceID(ctx, traceID(r))
+	}
+	var tg prometheus.Gatherer = ha
+	if ha == nil || ha.active() {
+		registry := prometheus.NewRegistry()
+		for _, t := range targets {
+			module := c.Modules[t.Module]
+			timeout, err := timeoutParam(r, module.Timeout)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			module.Timeout = timeout
 
-	up := float64(1)
-	_, err = c.Stats(e.address)
-	if err != nil {
-		level.Error(e.logger).Log("msg", "Failed to collect stats from memcached", "err", err)
-		up = 0
+			l := log.With(logger, "scrape_id", scrapeID(r.Context()), "target", t.Address, "module", t.Module)
+			prometheus.WrapRegistererWith(t.labels, registry).MustRegister(
+				newSampleCounter(newExporter(ctx, t.Target, module, l, exporterOpts...), t.Address, t.labels),
+				targetInfo{module: module},
+			)
+		}
+		tg = registry
+		if ha != nil {
+			tg = ha.record(registry)
+		}
+	}
+	var g prometheus.Gatherer = append(prometheus.Gatherers(gatherers), tg)
+	if len(c.MetricRules) > 0 {
+		g = relabelGatherer{g: g, rules: c.MetricRules}
+	}
+	if opts.maxSeries > 0 {
+		g = limitGatherer{g: g, max: opts.maxSeries, logger: scrapeLogger}
 	}
+	// Compression is handled by gzipHandler, so the level is configurable.
+	promhttp.HandlerFor(g, promhttp.HandlerOpts{DisableCompression: true, EnableOpenMetrics: opts.exemplars}).ServeHTTP(w, r)
+}
 
-	ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, up)
+// metricsHandler returns a handler that collects the metrics of the
+// configured targets on every request. The given labels and the labels of
+// each target are attached to all of their metrics. The collection timeout
+// can be overridden per request with the timeout URL parameter, e.g.
+// /metrics?timeout=5s. Only the targets of the shard of the options are
+// collected.
+func metricsHandler(store *configStore, labels prometheus.Labels, opts serveOptions, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := store.get()
+		// A metric must have the same label names for all targets, so the
+		// labels missing from a target are set to the empty value, which
+		// Prometheus treats as unset.
+		names := make(map[string]bool)
+		var owned []Target
+		for _, t := range c.Targets {
+			if opts.shard.owns(t.Address) {
+				owned = append(owned, t)
+			}
+		}
+		for _, t := range owned {
+			for k := range t.Labels {
+				names[k] = true
+			}
+		}
+		targets := make([]scrapeTarget, 0, len(owned))
+		for _, t := range owned {
+			tl := make(prometheus.Labels, len(labels)+len(names)+1)
+			for k, v := range labels {
+				tl[k] = v
+			}
+			for k := range names {
+				if _, ok := tl[k]; !ok {
+					tl[k] = ""
+				}
+			}
+			for k, v := range t.Labels {
+				tl[k] = v
+			}
+			if c.labelTargets {
+				tl["target"] = t.Address
+			}
+			targets = append(targets, scrapeTarget{Target: t, labels: tl})
+		}
+		serveTargets(w, r, c, targets, opts, logger, opts.gatherer)
+	}
 }
 
-// Describe describes all the metrics exported by the olric exporter. It
-// implements prometheus.Collector.
-func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+// probeHandler returns a handler that collects the metrics of the Olric
+// server given in the target URL parameter with the module given in the
+// module URL parameter, e.g. /probe?module=default&target=localhost:3320.
+func probeHandler(store *configStore, opts serveOptions, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		target := params.Get("target")
+		if target == "" {
+			http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		moduleName := params.Get("module")
+		if moduleName == "" {
+			moduleName = defaultModule
+		}
+		c := store.get()
+		module, ok := c.Modules[moduleName]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+			return
+		}
+		target, err := parseAddress(target, module.Transport)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid target: %v", err), http.StatusBadRequest)
+			return
+		}
+		targets := []scrapeTarget{{Target: Target{Address: target, Module: moduleName}}}
+		serveTargets(w, r, c, targets, opts, logger)
+	}
 }
 
-const namespace = "olric"
+// listen announces on the given address. Addresses prefixed with "unix:" are
+// treated as unix socket paths, everything else as TCP addresses.
+func listen(address string) (net.Listener, error) {
+	if path := strings.TrimPrefix(address, "unix:"); path != address {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
 
 func main() {
 	var (
-		address       = kingpin.Flag("olric.address", "Olric server address.").Default("localhost:3320").String()
-		timeout       = kingpin.Flag("olric.timeout", "olric connect timeout.").Default("1s").Duration()
-		listenAddress = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9150").String()
-		metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+		configFile           = kingpin.Flag("config.file", "Path to the configuration file defining probe modules and targets, or consul://<address>/<key> or etcd://<address>/<key> to read it from a KV store.").Default("").String()
+		targetsFile          = kingpin.Flag("config.targets-file", "Path of the file persisting the targets added through the admin API.").Default("").String()
+		adminTokenFile       = kingpin.Flag("web.admin-token-file", "Path of a file holding the bearer token of the admin API. The admin API is disabled if empty.").Default("").String()
+		configReloadInterval = kingpin.Flag("config.reload-interval", "How often the configuration file is checked for changes, 0 disables reloading. Keys of a KV store are watched instead.").Default("5s").Duration()
+		mode                 = kingpin.Flag("mode", "Deployment mode. In sidecar mode, pod, namespace and node labels are read from the POD_NAME, POD_NAMESPACE and NODE_NAME environment variables and attached to the metrics.").Default(modeStandalone).Enum(modeStandalone, modeSidecar)
+		address              = kingpin.Flag("olric.address", "Olric server address.").Default("localhost:3320").String()
+		healthCheckInterval  = kingpin.Flag("olric.health-check-interval", "How often the connections kept between scrapes by the olric transport are pinged, 0 disables the health checks.").Default("30s").Duration()
+		seeds                = kingpin.Flag("olric.seed", "Further address of the Olric cluster, tried if the server given in --olric.address cannot be reached. Can be repeated.").Strings()
+		timeout              = kingpin.Flag("olric.timeout", "Olric collection timeout, can be overridden with the timeout URL parameter.").Default("1s").Duration()
+		dialTimeout          = kingpin.Flag("olric.dial-timeout", "Timeout of connection establishment, --olric.timeout if 0.").Default("0s").Duration()
+		readTimeout          = kingpin.Flag("olric.read-timeout", "Timeout of every read of the resp transport and of the response of the http transport, 0 for none besides --olric.timeout. Not supported by the olric transport.").Default("0s").Duration()
+		writeTimeout         = kingpin.Flag("olric.write-timeout", "Timeout of every write of the resp transport, 0 for none besides --olric.timeout. Not supported by the olric transport.").Default("0s").Duration()
+		listenAddresses      = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry, can be repeated. Use unix:<path> for a unix socket.").Default(":9150").Strings()
+		metricsPath          = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+
+		disableCompression = kingpin.Flag("web.disable-compression", "Disable gzip compression of the metrics endpoint.").Default("false").Bool()
+		compressionLevel   = kingpin.Flag("web.compression-level", "Gzip compression level of the metrics endpoint, from 1 (fastest) to 9 (smallest), -1 for the default level.").Default("-1").Int()
+		dryRunFlag         = kingpin.Flag("dry-run", "Print the effective configuration and targets, including the ones added at runtime, then exit without listening.").Default("false").Bool()
+		scrapeIDHeaderFlag = kingpin.Flag("web.scrape-id-header", "Return the identifier of every scrape, which is included in its log lines, in the X-Scrape-Id response header.").Default("false").Bool()
+		corsOrigins        = kingpin.Flag("web.cors-origin", "Origin allowed to query the JSON API endpoints, can be repeated. Use * to allow any origin.").Strings()
+		metricsCompat      = kingpin.Flag("metrics.compat", "Also emit the metrics of the previous release under their former names.").Default("false").Bool()
+		collectorPlugins   = kingpin.Flag("collector.plugin", "Path of a Go plugin registering custom collectors, can be repeated.").Strings()
+		metricsNormalized  = kingpin.Flag("metrics.normalized-names", "Follow the Prometheus naming conventions for all metric names, e.g. the _bytes suffix for sizes.").Default("false").Bool()
+		maxSeries          = kingpin.Flag("metrics.max-series", "Maximum number of series of a scrape, the largest metric families are truncated beyond it. 0 disables the limit.").Default("0").Int()
+		roundRobin         = kingpin.Flag("olric.round-robin", "Start every scrape with the next of --olric.address and the --olric.seed addresses, instead of always with --olric.address.").Default("false").Bool()
+		watchdogThreshold  = kingpin.Flag("olric.watchdog-threshold", "Number of consecutive scrapes in which members of a target failed after which its client is rebuilt, 0 disables the watchdog.").Default("5").Int()
+		maxProcs           = kingpin.Flag("runtime.gomaxprocs", "Number of OS threads running Go code, 0 derives it from the CPU quota of the cgroup of the exporter.").Default("0").Int()
+		memLimit           = kingpin.Flag("runtime.memory-limit", "Soft memory limit of the Go runtime, e.g. 200MB, 0 derives it from the memory limit of the cgroup of the exporter.").Default("0").Bytes()
+		memLimitRatio      = kingpin.Flag("runtime.memory-limit-ratio", "Part of the memory limit of the cgroup used as soft memory limit.").Default("0.9").Float64()
+		scrapeSummary      = kingpin.Flag("log.scrape-summary-ratio", "Ratio of the collections whose summary is logged at debug level, from 0 to 1.").Default("1").Float64()
+		healthMaxAge       = kingpin.Flag("web.cluster-health-max-age", "Age of the last collection of a target beyond which /cluster-health collects it again, in addition to the timeout of its module.").Default("1m").Duration()
+		routingTable       = kingpin.Flag("metrics.routing-table", "Export the owner of every partition of the routing table, which adds a series per partition.").Default("false").Bool()
+		goCollectors       = kingpin.Flag("metrics.go-collectors", "Export the Go runtime and process metrics of the exporter on /metrics, disable with --no-metrics.go-collectors.").Default("true").Bool()
+		scope              = kingpin.Flag("olric.scope", "Scrape only the Olric server (local) or all members of its cluster (cluster). Defaults to local in sidecar mode and cluster otherwise.").String()
+		resolveMembers     = kingpin.Flag("olric.resolve-members", "Replace the IP addresses of the members by their host names in the labels, found with reverse DNS lookups.").Default("false").Bool()
+		resolveMembersTTL  = kingpin.Flag("olric.resolve-members-ttl", "How long the host names of the members are cached.").Default("5m").Duration()
+		traceExemplars     = kingpin.Flag("tracing.exemplars", "Attach the trace ID of the W3C traceparent header of a scrape as exemplar to the latency metrics, exposed in the OpenMetrics format.").Default("false").Bool()
+		shardIndex         = kingpin.Flag("shard.index", "Index of this replica among --shard.total replicas sharing the configured targets, from 0.").Default("0").Int()
+		shardTotal         = kingpin.Flag("shard.total", "Number of replicas sharing the configured targets, each scraping a disjoint part of them on /metrics.").Default("1").Int()
+		demo               = kingpin.Flag("demo", "Serve the randomized metrics of a fictitious cluster instead of scraping Olric, e.g. to develop dashboards and alerts.").Default("false").Bool()
+		sizeSampleInterval = kingpin.Flag("dmap.entry-size-sample-interval", "How often the sizes of a sample of the entries of every DMap are measured, 0 disables the sampling.").Default("0s").Duration()
+		sizeSampleLimit    = kingpin.Flag("dmap.entry-size-sample-limit", "Number of entries of every DMap measured per sample.").Default("1000").Int()
+		durationBuckets    = kingpin.Flag("metrics.stats-duration-buckets", "Comma separated upper bounds of the buckets of the stats duration histogram, in seconds.").Default(defaultDurationBuckets).String()
+		sizeBuckets        = kingpin.Flag("dmap.entry-size-buckets", "Comma separated upper bounds of the buckets of the entry size histogram, in bytes.").Default(defaultSizeBuckets).String()
+		haLockKey          = kingpin.Flag("ha.lock-key", "Key of the Olric lock electing the replica that collects the metrics, the others serve the metrics it cached. Empty disables the election.").Default("").String()
+		haLockDMap         = kingpin.Flag("ha.lock-dmap", "DMap holding the lock given in --ha.lock-key.").Default("olric_exporter").String()
+		haLease            = kingpin.Flag("ha.lease", "How long the lock is held without being renewed, i.e. until a standby replica takes over.").Default("15s").Duration()
+
+		_             = kingpin.Command("serve", "Run the exporter. This is the default command.").Default()
+		watchCmd      = kingpin.Command("watch", "Poll the stats of the Olric server and print the changing values.")
+		watchInterval = watchCmd.Flag("interval", "Polling interval.").Default("1s").Duration()
+		watchNoColor  = watchCmd.Flag("no-color", "Disable colorized output.").Default("false").Bool()
+		dumpCmd       = kingpin.Command("dump", "Print the raw stats of the Olric server as JSON.")
+
+		dashboardCmd   = kingpin.Command("dashboard", "Print a Grafana dashboard for the metrics of this exporter version.")
+		dashboardTitle = dashboardCmd.Flag("title", "Title of the dashboard.").Default("Olric").String()
+		dashboardUID   = dashboardCmd.Flag("uid", "UID of the dashboard, generated by Grafana if empty.").Default("").String()
+
+		rulesCmd           = kingpin.Command("rules", "Print a Prometheus rule file with default alerts.")
+		rulesFor           = rulesCmd.Flag("for", "How long a condition must hold before an alert fires.").Default("5m").Duration()
+		rulesQuorum        = rulesCmd.Flag("quorum", "Number of members that must be up.").Default("1").Int()
+		rulesFragmentation = rulesCmd.Flag("fragmentation-ratio", "Ratio of garbage to allocated DMap storage above which an alert fires.").Default("0.5").Float64()
+		rulesHitRatio      = rulesCmd.Flag("hit-ratio", "DMap get hit ratio below which an alert fires.").Default("0.8").Float64()
+
+		checkCmd              = kingpin.Command("check", "Check the health of the Olric cluster and exit with a Nagios status code.")
+		checkWarning          = checkCmd.Flag("warning", "Number of members below which the check is warning.").Default("1").Int()
+		checkCritical         = checkCmd.Flag("critical", "Number of members below which the check is critical.").Default("1").Int()
+		checkHitRatioWarning  = checkCmd.Flag("hit-ratio-warning", "DMap get hit ratio below which the check is warning, 0 to not check it.").Default("0").Float64()
+		checkHitRatioCritical = checkCmd.Flag("hit-ratio-critical", "DMap get hit ratio below which the check is critical.").Default("0").Float64()
+
+		selfTestCmd = kingpin.Command("self-test", "Scrape an embedded Olric node and check that all metrics are produced.")
+
+		benchCmd   = kingpin.Command("bench", "Measure the cost of collecting the metrics of the Olric server.")
+		benchCount = benchCmd.Flag("count", "Number of collections.").Short('n').Default("100").Int()
 	)
 	promlogConfig := &promlog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	cmd := kingpin.Parse()
 	logger := promlog.New(promlogConfig)
 
+	if err := validateCompressionLevel(*compressionLevel); err != nil {
+		level.Error(logger).Log("msg", "Invalid flag", "flag", "web.compression-level", "err", err)
+		os.Exit(1)
+	}
+
+	if *memLimitRatio <= 0 || *memLimitRatio > 1 {
+		level.Error(logger).Log("msg", "Invalid flag", "flag", "runtime.memory-limit-ratio", "err", "must be in (0, 1]")
+		os.Exit(1)
+	}
+	setLimits(*maxProcs, int64(*memLimit), *memLimitRatio, logger)
+
+	sh := shard{index: *shardIndex, total: *shardTotal}
+	if err := sh.validate(); err != nil {
+		level.Error(logger).Log("msg", "Invalid flag", "flag", "shard.index", "err", err)
+		os.Exit(1)
+	}
+
+	if *resolveMembers {
+		memberNames = newNameResolver(*resolveMembersTTL)
+	}
+
+	if err := loadPlugins(*collectorPlugins); err != nil {
+		level.Error(logger).Log("msg", "Error loading collector plugins", "err", err)
+		os.Exit(1)
+	}
+
+	if *scope == "" {
+		*scope = scopeCluster
+		if *mode == modeSidecar {
+			*scope = scopeLocal
+		}
+	}
+
+	defaults := Module{
+		Timeout:         *timeout,
+		DialTimeout:     *dialTimeout,
+		ReadTimeout:     *readTimeout,
+		WriteTimeout:    *writeTimeout,
+		Serializer:      "msgpack",
+		MaxConn:         10,
+		Transport:       transportOlric,
+		HTTPPath:        "/api/v1/stats",
+		Scope:           *scope,
+		Collectors:      exporter.Collectors(),
+		Compat:          metricsCompat,
+		NormalizedNames: metricsNormalized,
+		RoutingTable:    routingTable,
+	}
+	defaultTarget := Target{Address: *address, Seeds: *seeds, RoundRobin: *roundRobin}
+	config, err := loadConfig(*configFile, defaults, defaultTarget)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error loading config", "file", *configFile, "err", err)
+		os.Exit(1)
+	}
+	// The targets of the config file replace the one of the flags, which is
+	// still used by the commands, the leader election and the sampler.
+	if err := config.validateTarget(&defaultTarget, map[string]bool{}); err != nil {
+		level.Error(logger).Log("msg", "Invalid --olric.address or --olric.seed", "err", err)
+		os.Exit(1)
+	}
+	module := config.Modules[defaultModule]
+	if *readTimeout > 0 || *writeTimeout > 0 {
+		var supported bool
+		for _, m := range config.Modules {
+			supported = supported || m.Transport != transportOlric
+		}
+		if !supported {
+			level.Warn(logger).Log("msg", "The read and write timeouts have no effect, no module uses the http or resp transport")
+		}
+	}
+
+	if *healthCheckInterval > 0 {
+		var checked bool
+		for _, m := range config.Modules {
+			checked = checked || m.Transport == transportOlric
+		}
+		if !checked {
+			level.Warn(logger).Log("msg", "The health checks have no effect, no module uses the olric transport")
+		}
+	}
+
+	switch cmd {
+	case watchCmd.FullCommand():
+		if err := watch(os.Stdout, defaultTarget.Address, module, *watchInterval, !*watchNoColor); err != nil {
+			level.Error(logger).Log("msg", "Error watching stats", "err", err)
+			os.Exit(1)
+		}
+		return
+	case dumpCmd.FullCommand():
+		if err := dump(os.Stdout, defaultTarget.Address, module); err != nil {
+			level.Error(logger).Log("msg", "Error dumping stats", "err", err)
+			os.Exit(1)
+		}
+		return
+	case dashboardCmd.FullCommand():
+		e := newExporter(context.Background(), defaultTarget, module, logger)
+		if err := writeDashboard(os.Stdout, e, *dashboardTitle, *dashboardUID); err != nil {
+			level.Error(logger).Log("msg", "Error writing dashboard", "err", err)
+			os.Exit(1)
+		}
+		return
+	case rulesCmd.FullCommand():
+		rc := rulesConfig{
+			For:                *rulesFor,
+			Quorum:             *rulesQuorum,
+			FragmentationRatio: *rulesFragmentation,
+			HitRatio:           *rulesHitRatio,
+			NormalizedNames:    enabled(module.NormalizedNames),
+		}
+		if err := writeRules(os.Stdout, rc); err != nil {
+			level.Error(logger).Log("msg", "Error writing rules", "err", err)
+			os.Exit(1)
+		}
+		return
+	case checkCmd.FullCommand():
+		os.Exit(check(os.Stdout, defaultTarget, module, checkConfig{
+			Warning:          *checkWarning,
+			Critical:         *checkCritical,
+			HitRatioWarning:  *checkHitRatioWarning,
+			HitRatioCritical: *checkHitRatioCritical,
+		}))
+	case selfTestCmd.FullCommand():
+		if err := selfTest(os.Stdout, module, logger); err != nil {
+			level.Error(logger).Log("msg", "Self-test failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	case benchCmd.FullCommand():
+		if err := bench(os.Stdout, defaultTarget.Address, module, *benchCount, logger); err != nil {
+			level.Error(logger).Log("msg", "Benchmark failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	buckets, err := parseBuckets(*durationBuckets)
+	if err != nil {
+		level.Error(logger).Log("msg", "Invalid flag", "flag", "metrics.stats-duration-buckets", "err", err)
+		os.Exit(1)
+	}
+	statsDuration = newStatsDuration(buckets)
+	// The buckets are checked even if sampling is disabled, so that they
+	// do not fail once it is enabled.
+	sizeBucketBounds, err := parseBuckets(*sizeBuckets)
+	if err != nil {
+		level.Error(logger).Log("msg", "Invalid flag", "flag", "dmap.entry-size-buckets", "err", err)
+		os.Exit(1)
+	}
+
+	store := newConfigStore(config)
+	if *targetsFile != "" {
+		if err := store.loadTargets(*targetsFile); err != nil {
+			level.Error(logger).Log("msg", "Error loading targets", "file", *targetsFile, "err", err)
+			os.Exit(1)
+		}
+	}
+	var adminToken string
+	if *adminTokenFile != "" {
+		data, err := ioutil.ReadFile(*adminTokenFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error reading admin token", "file", *adminTokenFile, "err", err)
+			os.Exit(1)
+		}
+		if adminToken = strings.TrimSpace(string(data)); adminToken == "" {
+			level.Error(logger).Log("msg", "Admin token is empty", "file", *adminTokenFile)
+			os.Exit(1)
+		}
+	}
+
+	if *dryRunFlag {
+		if err := dryRun(os.Stdout, store); err != nil {
+			level.Error(logger).Log("msg", "Invalid configuration", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	level.Info(logger).Log("msg", "Starting olric_exporter", "version", version.Info())
 	level.Info(logger).Log("msg", "Build context", "context", version.BuildContext())
 
-	prometheus.MustRegister(NewExporter(*address, *timeout, logger))
-	http.Handle(*metricsPath, promhttp.Handler())
+	var labels prometheus.Labels
+	if *mode == modeSidecar {
+		var missing []string
+		labels, missing = sidecarLabels(os.Getenv)
+		if len(missing) > 0 {
+			level.Warn(logger).Log("msg", "Downward API environment variables are not set", "vars", strings.Join(missing, ","))
+		}
+	}
+
+	clients.watchdog = *watchdogThreshold
+	if *healthCheckInterval > 0 {
+		go clients.healthCheck(*healthCheckInterval, logger)
+	}
+
+	registry := newRegistry(*goCollectors)
+	if *configFile != "" && *configReloadInterval > 0 {
+		src, err := newConfigSource(*configFile, *configReloadInterval)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error loading config", "file", *configFile, "err", err)
+			os.Exit(1)
+		}
+		// The reload metrics are only exposed if the configuration is
+		// watched, so that alerts on failed reloads do not fire without it.
+		registry.MustRegister(configReloadSuccess, configReloadSeconds)
+		go store.watch(*configFile, src, defaults, defaultTarget, *configReloadInterval, logger)
+	}
+	var ha *haElector
+	if *haLockKey != "" {
+		ha, err = newHAElector(defaultTarget, module, *haLockDMap, *haLockKey, *haLease, registry)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error setting up the HA lock", "err", err)
+			os.Exit(1)
+		}
+		go ha.run(logger)
+	}
+
+	var sampler *sizeSampler
+	if *sizeSampleInterval > 0 {
+		sampler, err = newSizeSampler(defaultTarget, module, *sizeSampleLimit, sizeBucketBounds)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error setting up the entry size sampling", "err", err)
+			os.Exit(1)
+		}
+		registry.MustRegister(sampler)
+		go sampler.run(*sizeSampleInterval, logger)
+	}
+
+	var handler, probe http.Handler = metricsHandler(store, labels, serveOptions{ha: ha, maxSeries: *maxSeries, shard: sh, gatherer: registry, summary: *scrapeSummary, exemplars: *traceExemplars}, logger),
+		probeHandler(store, serveOptions{maxSeries: *maxSeries, summary: *scrapeSummary, exemplars: *traceExemplars}, logger)
+	if *demo {
+		level.Warn(logger).Log("msg", "Running in demo mode, the metrics are made up")
+		handler = demoHandler(module, registry, logger)
+	}
+	handler = scrapeIDHandler(handler, *scrapeIDHeaderFlag)
+	probe = scrapeIDHandler(probe, *scrapeIDHeaderFlag)
+	if !*disableCompression {
+		handler = gzipHandler(handler, *compressionLevel)
+		probe = gzipHandler(probe, *compressionLevel)
+	}
+	http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(registry, handler))
+	http.Handle("/probe", probe)
+	http.Handle("/api/v1/stats", corsHandler(statsHandler(store, logger), *corsOrigins))
+	http.Handle("/cluster-health", corsHandler(clusterHealthHandler(store, *healthMaxAge, logger), *corsOrigins))
+	http.Handle("/api/v1/targets", corsHandler(targetsHandler(store, adminToken, logger), *corsOrigins))
+	http.Handle("/metrics-docs", docsHandler(store, logger))
+	http.Handle("/-/refresh", refreshHandler(adminToken, ha, sampler, logger))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`<html>
              <head><title>Olric Exporter</title></head>
              <body>
              <h1>Olric Exporter</h1>
              <p><a href='` + *metricsPath + `'>Metrics</a></p>
+             <p><a href='/metrics-docs'>Metrics documentation</a></p>
+             <p><a href='/cluster-health'>Cluster health</a></p>
+             <p><a href='/probe?target=localhost:3320'>Probe localhost:3320</a></p>
              </body>
              </html>`))
 	})
 
-	level.Info(logger).Log("msg", "Listening on address", "address", *listenAddress)
-	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
+	listeners := make([]net.Listener, 0, len(*listenAddresses))
+	for _, address := range *listenAddresses {
+		l, err := listen(address)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error listening on address", "address", address, "err", err)
+			os.Exit(1)
+		}
+		listeners = append(listeners, l)
+	}
+
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		level.Info(logger).Log("msg", "Listening on address", "address", l.Addr())
+		go func(l net.Listener) {
+			errCh <- http.Serve(l, nil)
+		}(l)
+	}
+	if err := <-errCh; err != nil {
 		level.Error(logger).Log("msg", "Error running HTTP server", "err", err)
 		os.Exit(1)
 	}
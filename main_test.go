@@ -0,0 +1,64 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPartitionParam(t *testing.T) {
+	tests := []struct {
+		param   string
+		keep    []uint64
+		drop    []uint64
+		wantErr bool
+	}{
+		{param: "", keep: []uint64{0, 42, 270}},
+		{param: "42", keep: []uint64{42}, drop: []uint64{0, 41, 43}},
+		{param: "0-9,42", keep: []uint64{0, 5, 9, 42}, drop: []uint64{10, 41}},
+		{param: " 3 - 4 ", keep: []uint64{3, 4}, drop: []uint64{2, 5}},
+		{param: "9-0", wantErr: true},
+		{param: "a", wantErr: true},
+		{param: "1,", wantErr: true},
+		{param: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		q := r.URL.Query()
+		if tt.param != "" {
+			q.Set("partition", tt.param)
+		}
+		r.URL.RawQuery = q.Encode()
+		keep, err := partitionParam(r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("partitionParam(%q) error = %v, want error %v", tt.param, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for _, id := range tt.keep {
+			if keep != nil && !keep(id) {
+				t.Errorf("partitionParam(%q) drops partition %d", tt.param, id)
+			}
+		}
+		for _, id := range tt.drop {
+			if keep == nil || keep(id) {
+				t.Errorf("partitionParam(%q) keeps partition %d", tt.param, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/buraksezer/olric/stats"
+)
+
+// StatsClient fetches the statistics of the members of an Olric cluster. It
+// decouples the collectors from the Olric client, so that other transports
+// and Olric versions can be supported by converting their statistics to
+// stats.Stats.
+type StatsClient interface {
+	// Stats returns the statistics of the member at address. It must give
+	// up when ctx is done.
+	Stats(ctx context.Context, address string) (stats.Stats, error)
+}
+
+// DMapCounters counts the DMap commands served by a member since it started.
+// The field names match the DMaps section of the statistics of Olric v0.5
+// and later, which are the only versions to count them.
+type DMapCounters struct {
+	GetHits   int64
+	GetMisses int64
+}
+
+// CounterClient is implemented by the clients that can report the DMap
+// command counters along the statistics of a member.
+type CounterClient interface {
+	// StatsWithCounters returns the statistics of the member at address and
+	// its counters, which are nil if the member does not report them.
+	StatsWithCounters(ctx context.Context, address string) (stats.Stats, *DMapCounters, error)
+}
+
+// MemberLister is implemented by the clients that can list the members of
+// the cluster, for Olric versions whose statistics do not name the owners of
+// the partitions.
+type MemberLister interface {
+	Members(ctx context.Context, address string) ([]string, error)
+}
+
+// ClusterStats fetches the statistics of every member of the cluster of the
+// Olric server at seed. The members are listed by c if it is a MemberLister,
+// or discovered from the routing table otherwise, and queried in parallel
+// until ctx is done. The members are asked to the cluster coordinator named
+// by seed, which holds the authoritative routing table, so that the same
+// members are scraped whichever member seed is; they are asked to seed if
+// the coordinator cannot be reached. If seed itself cannot be reached, the
+// error is reported for it.
+func ClusterStats(ctx context.Context, c StatsClient, seed string) []MemberStats {
+	ms := fetch(ctx, c, seed)
+	if ms.Err != nil {
+		return []MemberStats{ms}
+	}
+	s := ms.Stats
+	fetched := map[string]MemberStats{seed: ms}
+	source := seed
+	if coordinator := s.ClusterCoordinator.Name; coordinator != "" && coordinator != seed {
+		if cs := fetch(ctx, c, coordinator); cs.Err == nil {
+			fetched[coordinator] = cs
+			source = coordinator
+		}
+	}
+	members := Members(fetched[source].Stats)
+	if l, ok := c.(MemberLister); ok {
+		var err error
+		if members, err = l.Members(ctx, source); err != nil && source != seed {
+			members, err = l.Members(ctx, seed)
+		}
+		if err != nil {
+			return []MemberStats{{Member: seed, Err: err}}
+		}
+	}
+	if len(members) == 0 {
+		return []MemberStats{ms}
+	}
+
+	results := make([]MemberStats, len(members))
+	var wg sync.WaitGroup
+	for i, member := range members {
+		if ms, ok := fetched[member]; ok {
+			results[i] = ms
+			continue
+		}
+		wg.Add(1)
+		go func(i int, member string) {
+			defer wg.Done()
+			results[i] = fetch(ctx, c, member)
+		}(i, member)
+	}
+	wg.Wait()
+	return results
+}
+
+// fetch returns the statistics of the member at address, and its counters if
+// c is a CounterClient.
+func fetch(ctx context.Context, c StatsClient, address string) MemberStats {
+	ms := MemberStats{Member: address}
+	if cc, ok := c.(CounterClient); ok {
+		ms.Stats, ms.Counters, ms.Err = cc.StatsWithCounters(ctx, address)
+	} else {
+		ms.Stats, ms.Err = c.Stats(ctx, address)
+	}
+	return ms
+}
+
+// MockClient is a StatsClient returning fixed statistics, for tests and
+// demonstrations.
+type MockClient struct {
+	// Members holds the statistics returned for every member address.
+	Members map[string]stats.Stats
+
+	// Errors holds the errors returned for members that are down.
+	Errors map[string]error
+
+	// Counters holds the counters returned for the members that report
+	// them.
+	Counters map[string]DMapCounters
+}
+
+// Stats implements StatsClient.
+func (m *MockClient) Stats(ctx context.Context, address string) (stats.Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return stats.Stats{}, err
+	}
+	if err, ok := m.Errors[address]; ok {
+		return stats.Stats{}, err
+	}
+	s, ok := m.Members[address]
+	if !ok {
+		return stats.Stats{}, fmt.Errorf("unknown member %s", address)
+	}
+	return s, nil
+}
+
+// StatsWithCounters implements CounterClient.
+func (m *MockClient) StatsWithCounters(ctx context.Context, address string) (stats.Stats, *DMapCounters, error) {
+	s, err := m.Stats(ctx, address)
+	if err != nil {
+		return s, nil, err
+	}
+	if c, ok := m.Counters[address]; ok {
+		return s, &c, nil
+	}
+	return s, nil, nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/buraksezer/olric/stats"
+)
+
+// testStats returns the stats reported by a member of a cluster whose
+// coordinator is coordinator and whose partition i is owned by owners[i].
+func testStats(coordinator string, owners ...string) stats.Stats {
+	s := stats.Stats{
+		ReleaseVersion: "0.3.0",
+		Partitions:     make(map[uint64]stats.Partition),
+		Backups:        make(map[uint64]stats.Partition),
+	}
+	s.Runtime.Version = "go1.15"
+	s.Runtime.NumCPU = 4
+	s.ClusterCoordinator.Name = coordinator
+	for i, owner := range owners {
+		p := stats.Partition{Length: i + 1}
+		p.Owner.Name = owner
+		s.Partitions[uint64(i)] = p
+	}
+	return s
+}
+
+func testCluster() *MockClient {
+	return &MockClient{
+		Members: map[string]stats.Stats{
+			"a:3320": testStats("a:3320", "a:3320", "b:3320", "c:3320"),
+			"b:3320": testStats("a:3320", "a:3320", "b:3320", "c:3320"),
+		},
+		Errors: map[string]error{"c:3320": errors.New("connection refused")},
+	}
+}
+
+// countingClient counts the stats requests of every member.
+type countingClient struct {
+	StatsClient
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *countingClient) Stats(ctx context.Context, address string) (stats.Stats, error) {
+	c.mu.Lock()
+	c.calls[address]++
+	c.mu.Unlock()
+	return c.StatsClient.Stats(ctx, address)
+}
+
+// listingClient is a MockClient that lists the members of the cluster as
+// seen by every member.
+type listingClient struct {
+	*MockClient
+	lists map[string][]string
+}
+
+func (c listingClient) Members(ctx context.Context, address string) ([]string, error) {
+	members, ok := c.lists[address]
+	if !ok {
+		return nil, fmt.Errorf("no member list of %s", address)
+	}
+	return members, nil
+}
+
+// memberUp returns whether every member of results could be scraped.
+func memberUp(results []MemberStats) map[string]bool {
+	up := make(map[string]bool)
+	for _, ms := range results {
+		up[ms.Member] = ms.Err == nil
+	}
+	return up
+}
+
+func TestClusterStats(t *testing.T) {
+	lonely := testStats("", "")
+	// stale still routes to c:3320, which left the cluster.
+	stale := testStats("a:3320", "b:3320", "c:3320")
+	down := errors.New("connection refused")
+
+	tests := []struct {
+		name   string
+		client StatsClient
+		seed   string
+		want   map[string]bool
+	}{
+		{
+			name:   "seed down",
+			client: testCluster(),
+			seed:   "c:3320",
+			want:   map[string]bool{"c:3320": false},
+		},
+		{
+			name:   "unknown seed",
+			client: testCluster(),
+			seed:   "d:3320",
+			want:   map[string]bool{"d:3320": false},
+		},
+		{
+			name:   "member down",
+			client: testCluster(),
+			seed:   "b:3320",
+			want:   map[string]bool{"a:3320": true, "b:3320": true, "c:3320": false},
+		},
+		{
+			name: "members of the coordinator",
+			client: &MockClient{Members: map[string]stats.Stats{
+				"a:3320": testStats("a:3320", "a:3320", "b:3320"),
+				"b:3320": stale,
+			}},
+			seed: "b:3320",
+			want: map[string]bool{"a:3320": true, "b:3320": true},
+		},
+		{
+			name: "coordinator down",
+			client: &MockClient{
+				Members: map[string]stats.Stats{"b:3320": testStats("a:3320", "b:3320", "c:3320")},
+				Errors:  map[string]error{"a:3320": down},
+			},
+			seed: "b:3320",
+			want: map[string]bool{"a:3320": false, "b:3320": true, "c:3320": false},
+		},
+		{
+			name:   "no member",
+			client: &MockClient{Members: map[string]stats.Stats{"a:3320": lonely}},
+			seed:   "a:3320",
+			want:   map[string]bool{"a:3320": true},
+		},
+		{
+			name: "lister",
+			client: listingClient{
+				MockClient: testCluster(),
+				lists:      map[string][]string{"a:3320": {"a:3320", "b:3320"}},
+			},
+			seed: "b:3320",
+			want: map[string]bool{"a:3320": true, "b:3320": true},
+		},
+		{
+			name: "lister of the seed",
+			client: listingClient{
+				MockClient: &MockClient{
+					Members: map[string]stats.Stats{"b:3320": testStats("a:3320")},
+					Errors:  map[string]error{"a:3320": down},
+				},
+				lists: map[string][]string{"b:3320": {"b:3320", "c:3320"}},
+			},
+			seed: "b:3320",
+			want: map[string]bool{"b:3320": true, "c:3320": false},
+		},
+		{
+			name:   "lister fails",
+			client: listingClient{MockClient: testCluster()},
+			seed:   "b:3320",
+			want:   map[string]bool{"b:3320": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := ClusterStats(context.Background(), tt.client, tt.seed)
+			if got := memberUp(results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ClusterStats(%q) = %v, want %v", tt.seed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClusterStatsFetchesOnce(t *testing.T) {
+	c := &countingClient{StatsClient: testCluster(), calls: make(map[string]int)}
+	ClusterStats(context.Background(), c, "b:3320")
+	want := map[string]int{"a:3320": 1, "b:3320": 1, "c:3320": 1}
+	if !reflect.DeepEqual(c.calls, want) {
+		t.Errorf("got stats requests %v, want %v", c.calls, want)
+	}
+}
+
+func TestClusterStatsCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := ClusterStats(ctx, testCluster(), "b:3320")
+	if len(results) != 1 || !errors.Is(results[0].Err, context.Canceled) {
+		t.Errorf("ClusterStats() = %+v, want the seed canceled", results)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/buraksezer/olric/stats"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a custom group of metrics computed from the statistics of a
+// member, e.g. site-specific figures derived from the DMaps. Collectors are
+// added with Register and enabled by name like the built-in ones.
+type Collector interface {
+	// Describe sends the descriptors of the metrics of the collector. The
+	// first variable label of every metric must be "member".
+	Describe(ch chan<- *prometheus.Desc)
+
+	// Collect sends the metrics computed from the statistics s of member.
+	// It is called concurrently by the exporters collected at the same
+	// time, and must not modify s.
+	Collect(ch chan<- prometheus.Metric, member string, s stats.Stats)
+}
+
+var (
+	registeredMu sync.RWMutex
+	registered   = make(map[string]Collector)
+)
+
+// Register makes a custom collector available under name. It is meant to be
+// called from the init function of the package defining the collector,
+// including Go plugins loaded by the exporter. It panics if name is already
+// taken.
+func Register(name string, c Collector) {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	if _, ok := registered[name]; ok || isBuiltin(name) {
+		panic("exporter: collector " + name + " is already registered")
+	}
+	registered[name] = c
+}
+
+// Collectors returns the names of the built-in collectors followed by the
+// names of the registered ones, sorted.
+func Collectors() []string {
+	registeredMu.RLock()
+	defer registeredMu.RUnlock()
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append(append([]string{}, CollectorNames...), names...)
+}
+
+func registeredCollector(name string) (Collector, bool) {
+	registeredMu.RLock()
+	defer registeredMu.RUnlock()
+	c, ok := registered[name]
+	return c, ok
+}
+
+func isBuiltin(name string) bool {
+	for _, c := range CollectorNames {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// custom adapts a registered collector to the built-in ones.
+func custom(c Collector) collector {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	var descs []*prometheus.Desc
+	for d := range ch {
+		descs = append(descs, d)
+	}
+	return collector{descs: descs, collect: c.Collect}
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ErrorReasons are the reasons returned by ErrorReason, which keep the
+// reason label of the scrape error metric bounded.
+var ErrorReasons = []string{"timeout", "refused", "dns", "network", "protocol", "other"}
+
+// ErrorReason classifies an error of a collection. An error whose chain
+// holds an error with a Reason method returning one of ErrorReasons has
+// that reason.
+func ErrorReason(err error) string {
+	var r interface{ Reason() string }
+	if errors.As(err, &r) {
+		for _, reason := range ErrorReasons {
+			if r.Reason() == reason {
+				return reason
+			}
+		}
+	}
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.As(err, &opErr):
+		return "network"
+	}
+	return "other"
+}
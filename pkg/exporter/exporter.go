@@ -0,0 +1,743 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package exporter implements a Prometheus collector for the statistics of
+// an Olric member. The statistics can be fetched over the network or read
+// directly from an embedded Olric instance.
+package exporter
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buraksezer/olric"
+	"github.com/buraksezer/olric/stats"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace is the prefix of the names of all exported metrics.
+const Namespace = "olric"
+
+// CollectorNames lists the built-in groups of metrics that can be enabled.
+var CollectorNames = []string{"runtime", "partitions", "dmaps"}
+
+// IsCollector returns whether name is one of CollectorNames or a registered
+// collector.
+func IsCollector(name string) bool {
+	if isBuiltin(name) {
+		return true
+	}
+	_, ok := registeredCollector(name)
+	return ok
+}
+
+// MetricInfo describes a metric family exported by the exporter.
+type MetricInfo struct {
+	Name      string
+	Subsystem string
+	Help      string
+	ValueType prometheus.ValueType
+	Labels    []string
+
+	// Cluster is set for metrics that compare members, which are only
+	// exported if several members of a replicated cluster are scraped.
+	Cluster bool
+
+	// Counted is set for metrics of the command counters, which are only
+	// exported for the members whose client reports them.
+	Counted bool
+
+	// OnError is set for metrics only exported for the members that could
+	// not be scraped.
+	OnError bool
+}
+
+// collector emits one group of metrics from the statistics of a member.
+// Metrics that compare members are emitted by collectCluster, if set, once
+// all members are scraped.
+type collector struct {
+	descs           []*prometheus.Desc
+	collect         func(ch chan<- prometheus.Metric, member string, s stats.Stats)
+	collectCluster  func(ch chan<- prometheus.Metric, members map[string]stats.Stats)
+	collectCounters func(ch chan<- prometheus.Metric, member string, c DMapCounters)
+}
+
+// MemberStats holds the statistics of a member of the cluster, or the error
+// that prevented fetching them.
+type MemberStats struct {
+	// Member is the name of the member, host:port as known by the cluster.
+	Member string
+	Stats  stats.Stats
+	Err    error
+
+	// Counters are the DMap command counters of the member, if its client
+	// reports them.
+	Counters *DMapCounters
+}
+
+// StatsFunc returns the statistics of the members to export. It is called on
+// every collection, concurrently if the exporter is collected concurrently,
+// and must return statistics that are not modified afterwards.
+type StatsFunc func() []MemberStats
+
+// Members returns the names of the members of the cluster that appear in the
+// routing table of s, sorted by name.
+func Members(s stats.Stats) []string {
+	seen := make(map[string]bool)
+	add := func(m string) {
+		if m != "" {
+			seen[m] = true
+		}
+	}
+	add(s.ClusterCoordinator.Name)
+	for _, partitions := range []map[uint64]stats.Partition{s.Partitions, s.Backups} {
+		for _, p := range partitions {
+			add(p.Owner.Name)
+			for _, b := range p.Backups {
+				add(b.Name)
+			}
+		}
+	}
+	members := make([]string, 0, len(seen))
+	for m := range seen {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Exporter collects the statistics of the members of an Olric cluster and
+// exports them as Prometheus metrics. All metrics of a member carry a member
+// label.
+//
+// An Exporter is not modified after New, so it can be collected
+// concurrently, e.g. by a registry shared by simultaneous scrapes, provided
+// its StatsFunc, the keep function of WithPartitions and the custom
+// collectors are safe for concurrent use.
+type Exporter struct {
+	stats      StatsFunc
+	enabled    []string
+	logger     log.Logger
+	compat     bool
+	normalized bool
+	partitions func(partID uint64) bool
+	summary    float64
+	routing    bool
+
+	collectors map[string]collector
+	infos      map[*prometheus.Desc]MetricInfo
+	legacy     map[*prometheus.Desc]*prometheus.Desc
+
+	up              *prometheus.Desc
+	scrapeError     *prometheus.Desc
+	legacyUp        *prometheus.Desc
+	buildInfo       *prometheus.Desc
+	coordinator     *prometheus.Desc
+	configInfo      *prometheus.Desc
+	numCPU          *prometheus.Desc
+	numGoroutine    *prometheus.Desc
+	memAlloc        *prometheus.Desc
+	memHeapInuse    *prometheus.Desc
+	memSys          *prometheus.Desc
+	numGC           *prometheus.Desc
+	partitionLength *prometheus.Desc
+	backupLag       *prometheus.Desc
+	memberKeys      *prometheus.Desc
+	clusterKeys     *prometheus.Desc
+	missing         *prometheus.Desc
+	dmapCount       *prometheus.Desc
+	dmapLength      *prometheus.Desc
+	dmapNumTables   *prometheus.Desc
+	dmapSlabAlloc   *prometheus.Desc
+	dmapSlabInuse   *prometheus.Desc
+	dmapSlabGarbage *prometheus.Desc
+	dmapGetHits     *prometheus.Desc
+	dmapGetMisses   *prometheus.Desc
+	routingOwner    *prometheus.Desc
+}
+
+// Option configures an Exporter.
+type Option func(*Exporter)
+
+// WithCompat makes the exporter also emit the metrics of the previous release
+// under their former names, so that dashboards and alerts can be migrated
+// after an upgrade. The legacy metrics are deprecated.
+func WithCompat() Option {
+	return func(e *Exporter) { e.compat = true }
+}
+
+// WithNormalizedNames makes the exporter follow the Prometheus naming
+// conventions: byte sizes end in _bytes, counters in _total, and gauges do
+// not end in _total or _count. Together with WithCompat, the metrics are
+// also emitted under their former names.
+func WithNormalizedNames() Option {
+	return func(e *Exporter) { e.normalized = true }
+}
+
+// WithPartitions restricts the partition metrics to the partitions for which
+// keep returns true, e.g. to debug a hot partition without exporting the
+// series of all partitions.
+func WithPartitions(keep func(partID uint64) bool) Option {
+	return func(e *Exporter) { e.partitions = keep }
+}
+
+// WithSummary makes the exporter log a summary of a ratio of its
+// collections at debug level, with their duration, the number of members
+// scraped and failed, and the number of metric families and samples emitted.
+func WithSummary(ratio float64) Option {
+	return func(e *Exporter) { e.summary = ratio }
+}
+
+// WithRoutingTable makes the exporter also export the owner of every
+// partition, as seen by the cluster coordinator if it is scraped. This adds
+// a series per partition, 271 by default, which is why it is optional.
+func WithRoutingTable() Option {
+	return func(e *Exporter) { e.routing = true }
+}
+
+// normalizedNames maps the names of the metrics that do not follow the
+// Prometheus naming conventions to their normalized names. All values are
+// already in base units, so only the names change.
+var normalizedNames = map[string]string{
+	Namespace + "_runtime_memstats_alloc":      Namespace + "_runtime_memstats_alloc_bytes",
+	Namespace + "_runtime_memstats_heap_inuse": Namespace + "_runtime_memstats_heap_inuse_bytes",
+	Namespace + "_runtime_memstats_sys":        Namespace + "_runtime_memstats_sys_bytes",
+	Namespace + "_runtime_memstats_num_gc":     Namespace + "_runtime_memstats_num_gc_total",
+	Namespace + "_member_keys_total":           Namespace + "_member_keys",
+	Namespace + "_cluster_keys_total":          Namespace + "_cluster_keys",
+	Namespace + "_dmap_count":                  Namespace + "_dmaps",
+	Namespace + "_dmap_slab_allocated":         Namespace + "_dmap_slab_allocated_bytes",
+	Namespace + "_dmap_slab_inuse":             Namespace + "_dmap_slab_inuse_bytes",
+	Namespace + "_dmap_slab_garbage":           Namespace + "_dmap_slab_garbage_bytes",
+}
+
+// NormalizedName returns the name of the metric called name when
+// WithNormalizedNames is used.
+func NormalizedName(name string) string {
+	if n, ok := normalizedNames[name]; ok {
+		return n
+	}
+	return name
+}
+
+// newDesc creates a descriptor of a metric of a member. The member label is
+// always prepended.
+func (e *Exporter) newDesc(valueType prometheus.ValueType, subsystem, name, help string, labels ...string) *prometheus.Desc {
+	return e.newClusterDesc(valueType, subsystem, name, help, append([]string{"member"}, labels...)...)
+}
+
+// newClusterDesc creates a descriptor of a metric of the whole cluster and
+// records its metadata, which is not accessible from a prometheus.Desc.
+// If the name is normalized and compatibility is enabled, a descriptor with
+// the former name is recorded as well.
+func (e *Exporter) newClusterDesc(valueType prometheus.ValueType, subsystem, name, help string, labels ...string) *prometheus.Desc {
+	fqName := prometheus.BuildFQName(Namespace, subsystem, name)
+	var legacyName string
+	if n := NormalizedName(fqName); e.normalized && n != fqName {
+		legacyName, fqName = fqName, n
+	}
+	d := prometheus.NewDesc(fqName, help, labels, nil)
+	e.infos[d] = MetricInfo{Name: fqName, Subsystem: subsystem, Help: help, ValueType: valueType, Labels: labels}
+	if e.compat && legacyName != "" {
+		legacyHelp := help + " Deprecated, use " + fqName + "."
+		ld := prometheus.NewDesc(legacyName, legacyHelp, labels, nil)
+		e.infos[ld] = MetricInfo{Name: legacyName, Subsystem: subsystem, Help: legacyHelp, ValueType: valueType, Labels: labels}
+		e.legacy[d] = ld
+	}
+	return d
+}
+
+// emit sends a metric described by d, and its legacy version if any.
+func (e *Exporter) emit(ch chan<- prometheus.Metric, d *prometheus.Desc, value float64, labelValues ...string) {
+	valueType := e.infos[d].ValueType
+	ch <- prometheus.MustNewConstMetric(d, valueType, value, labelValues...)
+	if ld, ok := e.legacy[d]; ok {
+		ch <- prometheus.MustNewConstMetric(ld, valueType, value, labelValues...)
+	}
+}
+
+// describe sends d, and its legacy version if any.
+func (e *Exporter) describe(ch chan<- *prometheus.Desc, d *prometheus.Desc) {
+	ch <- d
+	if ld, ok := e.legacy[d]; ok {
+		ch <- ld
+	}
+}
+
+// clusterInfo marks the metric described by d as comparing members.
+func (e *Exporter) clusterInfo(d *prometheus.Desc) {
+	info := e.infos[d]
+	info.Cluster = true
+	e.infos[d] = info
+}
+
+// countedInfo marks the metric described by d as a command counter.
+func (e *Exporter) countedInfo(d *prometheus.Desc) {
+	info := e.infos[d]
+	info.Counted = true
+	e.infos[d] = info
+}
+
+// errorInfo marks the metric described by d as only exported on failures.
+func (e *Exporter) errorInfo(d *prometheus.Desc) {
+	info := e.infos[d]
+	info.OnError = true
+	e.infos[d] = info
+}
+
+// New returns an exporter of the statistics returned by fn. Only the given
+// collectors are enabled, or all built-in and registered ones if none is
+// given.
+func New(fn StatsFunc, collectors []string, logger log.Logger, opts ...Option) *Exporter {
+	if len(collectors) == 0 {
+		collectors = Collectors()
+	}
+	e := &Exporter{
+		stats:   fn,
+		enabled: collectors,
+		logger:  logger,
+		infos:   make(map[*prometheus.Desc]MetricInfo),
+		legacy:  make(map[*prometheus.Desc]*prometheus.Desc),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.up = e.newDesc(prometheus.GaugeValue, "member", "up",
+		"Could the member of the Olric cluster be reached.")
+	e.scrapeError = e.newDesc(prometheus.GaugeValue, "member", "scrape_error_info",
+		"Why the member of the Olric cluster could not be reached, one of "+strings.Join(ErrorReasons, ", ")+".", "reason")
+	e.errorInfo(e.scrapeError)
+	e.legacyUp = e.newClusterDesc(prometheus.GaugeValue, "", "up",
+		"Could all scraped members of the Olric cluster be reached. Deprecated, use olric_member_up.")
+	e.buildInfo = e.newDesc(prometheus.GaugeValue, "", "build_info",
+		"Release and Go versions of the Olric server.", "version", "go_version", "goos", "goarch")
+	e.coordinator = e.newDesc(prometheus.GaugeValue, "cluster", "coordinator_info",
+		"The cluster coordinator as seen by the Olric server.", "coordinator")
+	e.configInfo = e.newDesc(prometheus.GaugeValue, "", "config_info",
+		"Cluster configuration as seen by the Olric server, derived from its routing table.", "partition_count", "replica_count")
+	e.missing = e.newDesc(prometheus.GaugeValue, "exporter", "missing_sections",
+		"Whether a section of the statistics was missing from the reply of the Olric server, whose metrics are left out.", "section")
+	e.numCPU = e.newDesc(prometheus.GaugeValue, "runtime", "num_cpu",
+		"Number of logical CPUs usable by the Olric server.")
+	e.numGoroutine = e.newDesc(prometheus.GaugeValue, "runtime", "num_goroutine",
+		"Number of goroutines of the Olric server.")
+	e.memAlloc = e.newDesc(prometheus.GaugeValue, "runtime", "memstats_alloc",
+		"Bytes of allocated heap objects.")
+	e.memHeapInuse = e.newDesc(prometheus.GaugeValue, "runtime", "memstats_heap_inuse",
+		"Bytes in in-use heap spans.")
+	e.memSys = e.newDesc(prometheus.GaugeValue, "runtime", "memstats_sys",
+		"Bytes of memory obtained from the OS.")
+	e.numGC = e.newDesc(prometheus.CounterValue, "runtime", "memstats_num_gc",
+		"Number of completed GC cycles.")
+	e.partitionLength = e.newDesc(prometheus.GaugeValue, "partition", "length",
+		"Number of keys of a partition stored on the Olric server.", "partition", "kind")
+	e.backupLag = e.newDesc(prometheus.GaugeValue, "partition", "backup_lag",
+		"Number of keys of the primary copy of a partition missing from its backup on the member.", "partition")
+	e.clusterInfo(e.backupLag)
+	e.memberKeys = e.newDesc(prometheus.GaugeValue, "member", "keys_total",
+		"Number of keys in the primary partitions of the Olric server.")
+	e.clusterKeys = e.newClusterDesc(prometheus.GaugeValue, "cluster", "keys_total",
+		"Number of keys in the primary partitions of all scraped members of the cluster.")
+	e.dmapCount = e.newDesc(prometheus.GaugeValue, "dmap", "count",
+		"Number of DMaps with primary or backup partitions on the Olric server.")
+	e.dmapLength = e.newDesc(prometheus.GaugeValue, "dmap", "length",
+		"Number of keys of a DMap on the Olric server.", "dmap", "kind")
+	e.dmapNumTables = e.newDesc(prometheus.GaugeValue, "dmap", "num_tables",
+		"Number of storage tables of a DMap on the Olric server.", "dmap", "kind")
+	e.dmapSlabAlloc = e.newDesc(prometheus.GaugeValue, "dmap", "slab_allocated",
+		"Bytes allocated by the storage engine of a DMap.", "dmap", "kind")
+	e.dmapSlabInuse = e.newDesc(prometheus.GaugeValue, "dmap", "slab_inuse",
+		"Bytes in use in the storage engine of a DMap.", "dmap", "kind")
+	e.dmapSlabGarbage = e.newDesc(prometheus.GaugeValue, "dmap", "slab_garbage",
+		"Bytes of deleted entries in the storage engine of a DMap.", "dmap", "kind")
+	e.dmapGetHits = e.newDesc(prometheus.CounterValue, "dmap", "get_hits_total",
+		"Number of DMap gets served by the Olric server that found their key.")
+	e.countedInfo(e.dmapGetHits)
+	e.dmapGetMisses = e.newDesc(prometheus.CounterValue, "dmap", "get_misses_total",
+		"Number of DMap gets served by the Olric server that did not find their key.")
+	e.countedInfo(e.dmapGetMisses)
+	e.routingOwner = e.newClusterDesc(prometheus.GaugeValue, "routing", "partition_owner",
+		"The member owning the primary copy of a partition in the routing table of the cluster.", "partition", "member")
+	e.collectors = map[string]collector{
+		"runtime": {
+			descs:   []*prometheus.Desc{e.numCPU, e.numGoroutine, e.memAlloc, e.memHeapInuse, e.memSys, e.numGC},
+			collect: e.collectRuntime,
+		},
+		"partitions": {
+			descs:          []*prometheus.Desc{e.partitionLength, e.backupLag, e.memberKeys, e.clusterKeys},
+			collect:        e.collectPartitions,
+			collectCluster: e.collectClusterPartitions,
+		},
+		"dmaps": {
+			descs:           []*prometheus.Desc{e.dmapCount, e.dmapLength, e.dmapNumTables, e.dmapSlabAlloc, e.dmapSlabInuse, e.dmapSlabGarbage, e.dmapGetHits, e.dmapGetMisses},
+			collect:         e.collectDMaps,
+			collectCounters: e.collectDMapCounters,
+		},
+	}
+	for _, name := range collectors {
+		if c, ok := registeredCollector(name); ok {
+			e.collectors[name] = custom(c)
+		}
+	}
+	return e
+}
+
+// NewEmbedded returns an exporter that reads the statistics of an embedded
+// Olric member directly, without a network round trip. It can be registered
+// on the registry of the application embedding Olric. member is the name of
+// the embedded member, i.e. its bind address and port, and is used as the
+// member label.
+func NewEmbedded(db *olric.Olric, member string, collectors []string, logger log.Logger, opts ...Option) *Exporter {
+	return New(func() []MemberStats {
+		s, err := db.Stats()
+		return []MemberStats{{Member: member, Stats: s, Err: err}}
+	}, collectors, logger, opts...)
+}
+
+// Collect fetches the statistics of the Olric members, and delivers them as
+// Prometheus metrics. It implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	if e.summary <= 0 || rand.Float64() >= e.summary {
+		e.collect(ch)
+		return
+	}
+	start := time.Now()
+	metrics := make(chan prometheus.Metric)
+	var scraped, failed int
+	go func() {
+		scraped, failed = e.collect(metrics)
+		close(metrics)
+	}()
+	families := make(map[*prometheus.Desc]bool)
+	var samples int
+	for m := range metrics {
+		families[m.Desc()] = true
+		samples++
+		ch <- m
+	}
+	level.Debug(e.logger).Log("msg", "Collected stats from Olric", "duration_seconds", time.Since(start).Seconds(),
+		"members", scraped, "failed", failed, "families", len(families), "samples", samples)
+}
+
+// collect delivers the metrics of the Olric members, and returns the number
+// of members scraped and of members that failed.
+func (e *Exporter) collect(ch chan<- prometheus.Metric) (int, int) {
+	results := e.stats()
+	members := make(map[string]stats.Stats)
+	up := 1.0
+	var failed int
+	for _, ms := range results {
+		if ms.Err != nil {
+			up = 0
+			failed++
+			level.Error(e.logger).Log("msg", "Failed to collect stats from Olric", "member", ms.Member, "err", ms.Err)
+			e.emit(ch, e.up, 0, ms.Member)
+			e.emit(ch, e.scrapeError, 1, ms.Member, ErrorReason(ms.Err))
+			continue
+		}
+		e.emit(ch, e.up, 1, ms.Member)
+		e.collectMember(ch, ms)
+		members[ms.Member] = ms.Stats
+	}
+	for _, name := range e.enabled {
+		if c := e.collectors[name]; c.collectCluster != nil {
+			c.collectCluster(ch, members)
+		}
+	}
+	if e.routing {
+		e.collectRouting(ch, members)
+	}
+	if e.compat {
+		e.emit(ch, e.legacyUp, up)
+	}
+	return len(results), failed
+}
+
+// Sections of the statistics. Older Olric versions and other transports may
+// leave some out, which decodes to their zero value.
+const (
+	sectionRuntime     = "runtime"
+	sectionCoordinator = "coordinator"
+	sectionPartitions  = "partitions"
+	sectionBackups     = "backups"
+)
+
+var sections = []string{sectionRuntime, sectionCoordinator, sectionPartitions, sectionBackups}
+
+// missingSections returns the sections missing from s. Every member reports
+// all partitions of the cluster, so the maps of a reply are never empty.
+func missingSections(s stats.Stats) map[string]bool {
+	return map[string]bool{
+		sectionRuntime:     s.Runtime.Version == "" && s.Runtime.NumCPU == 0,
+		sectionCoordinator: s.ClusterCoordinator.Name == "",
+		sectionPartitions:  s.Partitions == nil,
+		sectionBackups:     s.Backups == nil,
+	}
+}
+
+func (e *Exporter) collectMember(ch chan<- prometheus.Metric, ms MemberStats) {
+	member, s := ms.Member, ms.Stats
+	missing := missingSections(s)
+	for _, section := range sections {
+		var v float64
+		if missing[section] {
+			v = 1
+		}
+		e.emit(ch, e.missing, v, member, section)
+	}
+	e.emit(ch, e.buildInfo, 1,
+		member, s.ReleaseVersion, s.Runtime.Version, s.Runtime.GOOS, s.Runtime.GOARCH)
+	if !missing[sectionCoordinator] {
+		e.emit(ch, e.coordinator, 1, member, s.ClusterCoordinator.String())
+	}
+	if !missing[sectionPartitions] {
+		e.emit(ch, e.configInfo, 1, member,
+			strconv.Itoa(len(s.Partitions)), strconv.Itoa(replicaCount(s)))
+	}
+	for _, name := range e.enabled {
+		c := e.collectors[name]
+		c.collect(ch, member, s)
+		if c.collectCounters != nil && ms.Counters != nil {
+			c.collectCounters(ch, member, *ms.Counters)
+		}
+	}
+}
+
+// Describe describes all the metrics exported by the olric exporter. It
+// implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.describe(ch, e.up)
+	e.describe(ch, e.scrapeError)
+	if e.compat {
+		e.describe(ch, e.legacyUp)
+	}
+	e.describe(ch, e.buildInfo)
+	e.describe(ch, e.coordinator)
+	e.describe(ch, e.configInfo)
+	e.describe(ch, e.missing)
+	if e.routing {
+		e.describe(ch, e.routingOwner)
+	}
+	for _, name := range e.enabled {
+		for _, d := range e.collectors[name].descs {
+			e.describe(ch, d)
+		}
+	}
+}
+
+// MetricInfos returns the metadata of the metrics described by e, in the
+// order of Describe. Metrics of registered collectors are left out.
+func (e *Exporter) MetricInfos() []MetricInfo {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		e.Describe(ch)
+		close(ch)
+	}()
+	var infos []MetricInfo
+	for d := range ch {
+		// The metadata of registered collectors is unknown.
+		if info, ok := e.infos[d]; ok {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// replicaCount returns the number of copies of the most replicated partition.
+// Olric does not report the configured replica count, but every partition has
+// that many copies once the cluster has enough members.
+func replicaCount(s stats.Stats) int {
+	n := 1
+	for _, p := range s.Partitions {
+		if len(p.Backups)+1 > n {
+			n = len(p.Backups) + 1
+		}
+	}
+	return n
+}
+
+func (e *Exporter) collectRuntime(ch chan<- prometheus.Metric, member string, s stats.Stats) {
+	if missingSections(s)[sectionRuntime] {
+		return
+	}
+	r := s.Runtime
+	e.emit(ch, e.numCPU, float64(r.NumCPU), member)
+	e.emit(ch, e.numGoroutine, float64(r.NumGoroutine), member)
+	e.emit(ch, e.memAlloc, float64(r.MemStats.Alloc), member)
+	e.emit(ch, e.memHeapInuse, float64(r.MemStats.HeapInuse), member)
+	e.emit(ch, e.memSys, float64(r.MemStats.Sys), member)
+	e.emit(ch, e.numGC, float64(r.MemStats.NumGC), member)
+}
+
+// owns returns whether member owns the primary or a backup of p.
+func owns(member string, p stats.Partition) bool {
+	if p.Owner.Name == member {
+		return true
+	}
+	for _, b := range p.Backups {
+		if b.Name == member {
+			return true
+		}
+	}
+	return false
+}
+
+// collectPartitions exports the partitions owned by member. Every member
+// reports all partitions of the cluster, the ones it does not own are only
+// exported if they hold keys, e.g. while they are being moved.
+func (e *Exporter) collectPartitions(ch chan<- prometheus.Metric, member string, s stats.Stats) {
+	if s.Partitions != nil {
+		e.emit(ch, e.memberKeys, float64(primaryKeys(s)), member)
+	}
+	for partID, p := range s.Partitions {
+		if (p.Length == 0 && !owns(member, p)) || !e.keepPartition(partID) {
+			continue
+		}
+		e.emit(ch, e.partitionLength, float64(p.Length),
+			member, strconv.FormatUint(partID, 10), "primary")
+	}
+	for partID, p := range s.Backups {
+		if (p.Length == 0 && !owns(member, p)) || !e.keepPartition(partID) {
+			continue
+		}
+		e.emit(ch, e.partitionLength, float64(p.Length),
+			member, strconv.FormatUint(partID, 10), "backup")
+	}
+}
+
+// keepPartition reports whether the metrics of partID are exported.
+func (e *Exporter) keepPartition(partID uint64) bool {
+	return e.partitions == nil || e.partitions(partID)
+}
+
+// primaryKeys returns the number of keys in the primary partitions of s.
+func primaryKeys(s stats.Stats) int {
+	var n int
+	for _, p := range s.Partitions {
+		n += p.Length
+	}
+	return n
+}
+
+func (e *Exporter) collectClusterPartitions(ch chan<- prometheus.Metric, members map[string]stats.Stats) {
+	if len(members) == 0 {
+		return
+	}
+	var keys int
+	var reported bool
+	for _, s := range members {
+		keys += primaryKeys(s)
+		reported = reported || s.Partitions != nil
+	}
+	if reported {
+		e.emit(ch, e.clusterKeys, float64(keys))
+	}
+	e.collectBackupLag(ch, members)
+}
+
+// collectBackupLag compares the length of every primary partition with the
+// length of its backups. Both the owner and the backup member have to be
+// scraped, so nothing is exported when scraping a single member.
+func (e *Exporter) collectBackupLag(ch chan<- prometheus.Metric, members map[string]stats.Stats) {
+	for owner, s := range members {
+		for partID, p := range s.Partitions {
+			if p.Owner.Name != owner || !e.keepPartition(partID) {
+				continue
+			}
+			for _, b := range p.Backups {
+				bs, ok := members[b.Name]
+				if !ok {
+					continue
+				}
+				lag := p.Length - bs.Backups[partID].Length
+				e.emit(ch, e.backupLag, float64(lag),
+					b.Name, strconv.FormatUint(partID, 10))
+			}
+		}
+	}
+}
+
+// collectRouting exports the owners of the partitions in the routing table
+// of the coordinator, which distributes it, or of the first scraped member in
+// name order if the coordinator is not scraped. Members may briefly disagree
+// while the table is being updated.
+func (e *Exporter) collectRouting(ch chan<- prometheus.Metric, members map[string]stats.Stats) {
+	var table *stats.Stats
+	var first string
+	for member, s := range members {
+		s := s
+		if s.Partitions == nil {
+			continue
+		}
+		if member == s.ClusterCoordinator.Name {
+			table = &s
+			break
+		}
+		if first == "" || member < first {
+			first, table = member, &s
+		}
+	}
+	if table == nil {
+		return
+	}
+	for partID, p := range table.Partitions {
+		if p.Owner.Name == "" || !e.keepPartition(partID) {
+			continue
+		}
+		e.emit(ch, e.routingOwner, 1, strconv.FormatUint(partID, 10), p.Owner.Name)
+	}
+}
+
+// collectDMapCounters exports the DMap command counters of a member.
+func (e *Exporter) collectDMapCounters(ch chan<- prometheus.Metric, member string, c DMapCounters) {
+	e.emit(ch, e.dmapGetHits, float64(c.GetHits), member)
+	e.emit(ch, e.dmapGetMisses, float64(c.GetMisses), member)
+}
+
+// collectDMaps aggregates the DMap statistics of all partitions of a kind.
+func (e *Exporter) collectDMaps(ch chan<- prometheus.Metric, member string, s stats.Stats) {
+	names := make(map[string]bool)
+	emit := func(kind string, partitions map[uint64]stats.Partition) {
+		dmaps := make(map[string]stats.DMap)
+		for _, p := range partitions {
+			for name, dm := range p.DMaps {
+				names[name] = true
+				total := dmaps[name]
+				total.Length += dm.Length
+				total.NumTables += dm.NumTables
+				total.SlabInfo.Allocated += dm.SlabInfo.Allocated
+				total.SlabInfo.Inuse += dm.SlabInfo.Inuse
+				total.SlabInfo.Garbage += dm.SlabInfo.Garbage
+				dmaps[name] = total
+			}
+		}
+		for name, dm := range dmaps {
+			e.emit(ch, e.dmapLength, float64(dm.Length), member, name, kind)
+			e.emit(ch, e.dmapNumTables, float64(dm.NumTables), member, name, kind)
+			e.emit(ch, e.dmapSlabAlloc, float64(dm.SlabInfo.Allocated), member, name, kind)
+			e.emit(ch, e.dmapSlabInuse, float64(dm.SlabInfo.Inuse), member, name, kind)
+			e.emit(ch, e.dmapSlabGarbage, float64(dm.SlabInfo.Garbage), member, name, kind)
+		}
+	}
+	emit("primary", s.Partitions)
+	emit("backup", s.Backups)
+	if s.Partitions != nil || s.Backups != nil {
+		e.emit(ch, e.dmapCount, float64(len(names)), member)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollectConcurrent(t *testing.T) {
+	mock := testCluster()
+	e := New(func() []MemberStats {
+		return ClusterStats(context.Background(), mock, "b:3320")
+	}, nil, log.NewNopLogger(), WithCompat(), WithSummary(1), WithRoutingTable())
+	want := testutil.CollectAndCount(e)
+
+	var wg sync.WaitGroup
+	counts := make([]int, 16)
+	for i := range counts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			counts[i] = testutil.CollectAndCount(e)
+		}(i)
+	}
+	wg.Wait()
+	for i, n := range counts {
+		if n != want {
+			t.Errorf("collection %d: got %d metrics, want %d", i, n, want)
+		}
+	}
+}
+
+func TestCollectDown(t *testing.T) {
+	mock := testCluster()
+	e := New(func() []MemberStats {
+		return ClusterStats(context.Background(), mock, "c:3320")
+	}, nil, log.NewNopLogger())
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			got[mf.GetName()] = m.GetGauge().GetValue()
+		}
+	}
+	if v, ok := got["olric_member_up"]; !ok || v != 0 {
+		t.Errorf("olric_member_up = %v, %v, want 0", v, ok)
+	}
+	if v := got["olric_member_scrape_error_info"]; v != 1 {
+		t.Errorf("olric_member_scrape_error_info = %v, want 1", v)
+	}
+}
+
+func TestCollectCounters(t *testing.T) {
+	mock := testCluster()
+	mock.Counters = map[string]DMapCounters{"a:3320": {GetHits: 8, GetMisses: 2}}
+	e := New(func() []MemberStats {
+		return ClusterStats(context.Background(), mock, "a:3320")
+	}, nil, log.NewNopLogger())
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			for _, l := range m.Label {
+				if l.GetName() != "member" {
+					continue
+				}
+				if got[mf.GetName()] == nil {
+					got[mf.GetName()] = make(map[string]float64)
+				}
+				got[mf.GetName()][l.GetValue()] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	want := map[string]map[string]float64{
+		"olric_dmap_get_hits_total":   {"a:3320": 8},
+		"olric_dmap_get_misses_total": {"a:3320": 2},
+	}
+	for name, members := range want {
+		if !reflect.DeepEqual(got[name], members) {
+			t.Errorf("%s = %v, want %v", name, got[name], members)
+		}
+	}
+}
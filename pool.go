@@ -0,0 +1,356 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// backoffBase and backoffMax bound the delay between reconnection
+	// attempts to a target that cannot be reached.
+	backoffBase = time.Second
+	backoffMax  = 2 * time.Minute
+
+	// clientIdleTimeout is how long a client is kept without being used,
+	// e.g. after its target was removed from the configuration.
+	clientIdleTimeout = 10 * time.Minute
+)
+
+var (
+	healthCheckFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "olric_exporter",
+		Name:      "client_health_check_failures_total",
+		Help:      "Number of failed pings of the connections to a target between scrapes.",
+	}, []string{"target"})
+	clientRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "olric_exporter",
+		Name:      "client_restarts_total",
+		Help:      "Number of times the client of a target was rebuilt by the watchdog after consecutive scrapes with failed members.",
+	}, []string{"target"})
+)
+
+// clientBackoffDesc describes the backoff delays collected by backoffCollector.
+var clientBackoffDesc = prometheus.NewDesc("olric_exporter_client_backoff_seconds",
+	"Delay before the next connection attempt to a target that could not be reached, 0 if it is connected or can be reconnected.",
+	[]string{"target"}, nil)
+
+// backoffCollector exports the remaining backoff delays of the clients of p,
+// computed when gathered, so that an expired delay is not exported until the
+// next scrape. The longest delay of the clients of a target is exported.
+type backoffCollector struct {
+	p *clientPool
+}
+
+func (c backoffCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clientBackoffDesc
+}
+
+func (c backoffCollector) Collect(ch chan<- prometheus.Metric) {
+	c.p.mu.Lock()
+	delays := make(map[string]time.Duration, len(c.p.clients))
+	now := time.Now()
+	for _, pc := range c.p.clients {
+		var d time.Duration
+		if pc.client == nil && pc.retryAt.After(now) {
+			d = pc.retryAt.Sub(now)
+		}
+		if cur, ok := delays[pc.address]; !ok || d > cur {
+			delays[pc.address] = d
+		}
+	}
+	c.p.mu.Unlock()
+	for address, d := range delays {
+		ch <- prometheus.MustNewConstMetric(clientBackoffDesc, prometheus.GaugeValue, d.Seconds(), address)
+	}
+}
+
+// pinger is implemented by the clients whose connections can be checked.
+type pinger interface {
+	Ping(ctx context.Context, address string) error
+}
+
+// backoffError is returned while no connection attempt is made to a target.
+type backoffError struct {
+	until time.Time
+	err   error
+}
+
+func (e backoffError) Error() string {
+	return fmt.Sprintf("not reconnecting until %s after: %v", e.until.Format(time.RFC3339), e.err)
+}
+
+func (e backoffError) Unwrap() error {
+	return e.err
+}
+
+// backoff returns the delay after the given number of consecutive failures.
+// It grows exponentially and is jittered between half and all of its value,
+// so that exporters do not reconnect in lockstep.
+func backoff(failures int) time.Duration {
+	d := backoffMax
+	if failures < 32 {
+		if e := backoffBase << uint(failures-1); e > 0 && e < backoffMax {
+			d = e
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+type pooledClient struct {
+	address  string
+	module   Module
+	client   statsClient
+	failures int
+	degraded int
+	lastErr  error
+	retryAt  time.Time
+	lastUsed time.Time
+}
+
+// clientPool keeps the clients of the targets across scrapes, so that their
+// connections are reused. A target that cannot be reached is reconnected
+// with exponential backoff instead of on every scrape.
+type clientPool struct {
+	mu      sync.Mutex
+	clients map[string]*pooledClient
+
+	// watchdog is the number of consecutive scrapes with failed members
+	// after which a client is rebuilt, 0 disables the watchdog.
+	watchdog int
+}
+
+var clients = &clientPool{clients: make(map[string]*pooledClient)}
+
+// poolKey identifies the clients that can be shared. The timeout is left
+// out since it can be overridden per scrape.
+func poolKey(address string, m Module) string {
+	return fmt.Sprintf("%s|%s|%s|%d|%s|%s|%s|%s|%s", address, m.Transport, m.Serializer, m.MaxConn, m.KeepAlive, m.HTTPPath,
+		m.DialTimeout, m.ReadTimeout, m.WriteTimeout)
+}
+
+// get returns the client of address, connecting it if needed.
+func (p *clientPool) get(address string, module Module) (statsClient, error) {
+	key := poolKey(address, module)
+	pc, c, reconnect, err := p.lookup(key, address, module)
+	if c != nil || err != nil {
+		return c, err
+	}
+	if reconnect {
+		// Go does not cache DNS answers, and a new client dials afresh, so
+		// a server rescheduled with a new IP is found again. The name is
+		// resolved upfront so that a lookup failure is reported as such
+		// rather than as a dial error. The lookup can take up to the
+		// timeout, so the other targets are not blocked meanwhile.
+		err = resolve(address, module.Timeout)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// The client may have been connected, or dropped from the pool, while
+	// the name was resolved.
+	if cur, ok := p.clients[key]; ok {
+		pc = cur
+	} else {
+		p.clients[key] = pc
+	}
+	if pc.client != nil {
+		return pc.client, nil
+	}
+	if err != nil {
+		p.failed(address, pc, err)
+		return nil, err
+	}
+	if c, err = newStatsClient(address, module); err != nil {
+		p.failed(address, pc, err)
+		return nil, err
+	}
+	pc.client = c
+	return c, nil
+}
+
+// lookup returns the pooled client of key, adding it if needed, and its
+// connected client, whether it failed before, or the backoff error if it is
+// not to be reconnected yet.
+func (p *clientPool) lookup(key, address string, module Module) (*pooledClient, statsClient, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sweep()
+	pc, ok := p.clients[key]
+	if !ok {
+		pc = &pooledClient{address: address, module: module}
+		p.clients[key] = pc
+	}
+	pc.lastUsed = time.Now()
+	if pc.client != nil {
+		return pc, pc.client, false, nil
+	}
+	if time.Now().Before(pc.retryAt) {
+		return pc, nil, false, backoffError{until: pc.retryAt, err: pc.lastErr}
+	}
+	return pc, nil, pc.failures > 0, nil
+}
+
+// resolve looks up the host of address, unless it is an IP address.
+func resolve(address string, timeout time.Duration) error {
+	host := address
+	if u, err := url.Parse(address); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		host = u.Hostname()
+	} else if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		return fmt.Errorf("error resolving %s: %w", host, err)
+	}
+	return nil
+}
+
+// report records the outcome of a request to address through c. The client
+// is dropped on failure, so that the next attempt reconnects after the
+// backoff delay.
+func (p *clientPool) report(address string, module Module, c statsClient, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc, ok := p.clients[poolKey(address, module)]
+	if !ok || pc.client != c {
+		return
+	}
+	if err == nil {
+		pc.failures, pc.lastErr = 0, nil
+		return
+	}
+	pc.client.Close()
+	pc.client = nil
+	p.failed(address, pc, err)
+}
+
+// watch records whether members failed in a scrape of address through c, a
+// client whose own server could be reached. The client keeps connections to
+// all members, which are not replaced when they get stuck, so it is rebuilt
+// after watchdog consecutive scrapes with failed members.
+func (p *clientPool) watch(address string, module Module, c statsClient, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc, ok := p.clients[poolKey(address, module)]
+	if !ok || pc.client != c {
+		return
+	}
+	if !failed {
+		pc.degraded = 0
+		return
+	}
+	pc.degraded++
+	if p.watchdog <= 0 || pc.degraded < p.watchdog {
+		return
+	}
+	pc.client.Close()
+	pc.client = nil
+	pc.degraded = 0
+	clientRestarts.WithLabelValues(address).Inc()
+}
+
+func (p *clientPool) failed(address string, pc *pooledClient, err error) {
+	pc.failures++
+	pc.lastErr = err
+	d := backoff(pc.failures)
+	pc.retryAt = time.Now().Add(d)
+}
+
+// healthCheck pings the connected clients every interval, so that a broken
+// connection, e.g. to a restarted server, is replaced before the next scrape
+// instead of making it time out. A failed ping drops the client like a
+// failed scrape. Only the clients of the olric transport are pinged: the
+// resp transport keeps no connection, and the http client retries the
+// requests that find their idle connection closed.
+func (p *clientPool) healthCheck(interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.mu.Lock()
+		connected := make([]*pooledClient, 0, len(p.clients))
+		for _, pc := range p.clients {
+			if pc.client != nil {
+				connected = append(connected, pc)
+			}
+		}
+		p.mu.Unlock()
+
+		for _, pc := range connected {
+			p.mu.Lock()
+			c := pc.client
+			p.mu.Unlock()
+			pg, ok := c.(pinger)
+			if !ok {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), pc.module.Timeout)
+			err := pg.Ping(ctx, pc.address)
+			cancel()
+			if err != nil {
+				level.Warn(logger).Log("msg", "Health check failed, reconnecting", "target", pc.address, "err", err)
+				healthCheckFailures.WithLabelValues(pc.address).Inc()
+			}
+			p.report(pc.address, pc.module, c, err)
+		}
+	}
+}
+
+// reset closes the clients of address, or all clients if address is empty,
+// and forgets their failures, so that the next scrape reconnects at once.
+// It returns the number of closed clients.
+func (p *clientPool) reset(address string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var n int
+	for key, pc := range p.clients {
+		if address != "" && pc.address != address {
+			continue
+		}
+		if pc.client != nil {
+			pc.client.Close()
+		}
+		delete(p.clients, key)
+		n++
+	}
+	return n
+}
+
+// sweep closes the clients that have not been used for clientIdleTimeout.
+func (p *clientPool) sweep() {
+	for key, pc := range p.clients {
+		if time.Since(pc.lastUsed) < clientIdleTimeout {
+			continue
+		}
+		if pc.client != nil {
+			pc.client.Close()
+		}
+		delete(p.clients, key)
+	}
+}
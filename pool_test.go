@@ -0,0 +1,117 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buraksezer/olric/stats"
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestServer serves the stats of member of mock as JSON, like the
+// /api/v1/stats endpoint.
+func newTestServer(t *testing.T, mock *exporter.MockClient, member string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := mock.Stats(r.Context(), member)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(s)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testModule() Module {
+	return Module{
+		Timeout:    time.Second,
+		MaxConn:    2,
+		Transport:  transportHTTP,
+		HTTPPath:   "/api/v1/stats",
+		Scope:      scopeCluster,
+		Collectors: exporter.Collectors(),
+	}
+}
+
+func TestFetchTargetConcurrent(t *testing.T) {
+	s := stats.Stats{ReleaseVersion: "0.3.0"}
+	s.ClusterCoordinator.Name = "a:3320"
+	mock := &exporter.MockClient{Members: map[string]stats.Stats{"a:3320": s}}
+	srv := newTestServer(t, mock, "a:3320")
+	target := Target{Address: srv.URL}
+	module := testModule()
+	e := newExporter(context.Background(), target, module, log.NewNopLogger())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for _, ms := range fetchTarget(context.Background(), target, module) {
+				if ms.Err != nil {
+					t.Errorf("fetching %s: %v", ms.Member, ms.Err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			testutil.CollectAndCount(e)
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := clients.get(srv.URL, module); err != nil {
+				t.Errorf("getting the client of %s: %v", srv.URL, err)
+			}
+		}()
+	}
+	wg.Wait()
+	clients.reset(srv.URL)
+}
+
+func TestClientPoolBackoff(t *testing.T) {
+	srv := newTestServer(t, &exporter.MockClient{}, "a:3320")
+	address := srv.URL
+	srv.Close()
+	module := testModule()
+	defer clients.reset(address)
+
+	results := fetchCluster(context.Background(), Target{Address: address}, module)
+	if len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("got %+v, want the failure of %s", results, address)
+	}
+	// The http client is reported broken and dropped, so the next attempt
+	// waits for the backoff delay.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := clients.get(address, module); err == nil {
+				t.Errorf("got a client of %s during its backoff", address)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newRegistry returns a registry of the metrics of the exporter itself, which
+// are served along the metrics of the targets on /metrics. The Go runtime and
+// process metrics of the exporter are included if goCollectors is set.
+func newRegistry(goCollectors bool) *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		scrapeErrors,
+		scrapeTimeouts,
+		coordinatorChanges,
+		unknownFields,
+		backoffCollector{p: clients},
+		healthCheckFailures,
+		clientRestarts,
+		clientConnsOpen,
+		clientConnsInUse,
+		clientConnWaits,
+		clientConnWaitSeconds,
+		statsDuration,
+	)
+	if goCollectors {
+		r.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	}
+	return r
+}
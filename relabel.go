@@ -0,0 +1,152 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	actionDrop     = "drop"
+	actionRename   = "rename"
+	actionAddLabel = "add_label"
+)
+
+// MetricRule changes the metric families whose name matches Name before they
+// are exposed. Rules are applied in order, so a rule matches the name given
+// by a previous rename.
+type MetricRule struct {
+	// Action is one of drop, rename and add_label.
+	Action string `yaml:"action"`
+
+	// Name is a regular expression matched against the whole metric name.
+	// It matches all metrics if empty.
+	Name string `yaml:"name,omitempty"`
+
+	// NewName is the name given by rename. It can refer to the capture
+	// groups of Name, e.g. ${1}_bytes.
+	NewName string `yaml:"new_name,omitempty"`
+
+	// Label and Value are the label set on all metrics by add_label.
+	Label string `yaml:"label,omitempty"`
+	Value string `yaml:"value,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func (r *MetricRule) compile() error {
+	re, err := regexp.Compile("^(?:" + r.Name + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid name: %w", err)
+	}
+	r.re = re
+	switch r.Action {
+	case actionDrop:
+	case actionRename:
+		if r.NewName == "" {
+			return fmt.Errorf("rename needs a new_name")
+		}
+	case actionAddLabel:
+		if !model.LabelName(r.Label).IsValid() {
+			return fmt.Errorf("invalid label name %q", r.Label)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	return nil
+}
+
+// apply applies the rule to mf and returns false if mf is dropped.
+func (r *MetricRule) apply(mf *dto.MetricFamily) (bool, error) {
+	name := mf.GetName()
+	match := r.re.FindStringSubmatchIndex(name)
+	if match == nil {
+		return true, nil
+	}
+	switch r.Action {
+	case actionDrop:
+		return false, nil
+	case actionRename:
+		newName := string(r.re.ExpandString(nil, r.NewName, name, match))
+		if !model.IsValidMetricName(model.LabelValue(newName)) {
+			return false, fmt.Errorf("renaming %s gives the invalid name %q", name, newName)
+		}
+		mf.Name = &newName
+	case actionAddLabel:
+		for _, m := range mf.Metric {
+			m.Label = setLabel(m.Label, r.Label, r.Value)
+		}
+	}
+	return true, nil
+}
+
+// setLabel sets the label name to value, keeping the labels sorted by name as
+// expected by the exposition.
+func setLabel(labels []*dto.LabelPair, name, value string) []*dto.LabelPair {
+	for _, l := range labels {
+		if l.GetName() == name {
+			l.Value = &value
+			return labels
+		}
+	}
+	labels = append(labels, &dto.LabelPair{Name: &name, Value: &value})
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+	return labels
+}
+
+// relabelGatherer applies the metric rules to the families gathered by g.
+// Families renamed to the same name are merged if they are of the same type.
+type relabelGatherer struct {
+	g     prometheus.Gatherer
+	rules []MetricRule
+}
+
+func (rg relabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := rg.g.Gather()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	result := families[:0]
+next:
+	for _, mf := range families {
+		for i := range rg.rules {
+			keep, err := rg.rules[i].apply(mf)
+			if err != nil {
+				return nil, err
+			}
+			if !keep {
+				continue next
+			}
+		}
+		if prev, ok := byName[mf.GetName()]; ok {
+			if prev.GetType() != mf.GetType() {
+				return nil, fmt.Errorf("metric rules merge %s families of different types", mf.GetName())
+			}
+			prev.Metric = append(prev.Metric, mf.Metric...)
+			continue
+		}
+		byName[mf.GetName()] = mf
+		result = append(result, mf)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].GetName() < result[j].GetName() })
+	return result, nil
+}
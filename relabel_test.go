@@ -0,0 +1,161 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// testFamily returns a family of n series, whose member labels are m0, m1...
+func testFamily(name string, kind dto.MetricType, n int) *dto.MetricFamily {
+	mf := &dto.MetricFamily{Name: &name, Type: &kind}
+	for i := 0; i < n; i++ {
+		label, value, v := "member", fmt.Sprintf("m%d", i), float64(i)
+		mf.Metric = append(mf.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: &label, Value: &value}},
+			Gauge: &dto.Gauge{Value: &v},
+		})
+	}
+	return mf
+}
+
+func testGatherer(families ...*dto.MetricFamily) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return families, nil
+	})
+}
+
+func TestMetricRuleCompile(t *testing.T) {
+	tests := []struct {
+		rule    MetricRule
+		wantErr bool
+	}{
+		{rule: MetricRule{Action: actionDrop, Name: "olric_partition_.*"}},
+		{rule: MetricRule{Action: actionDrop}},
+		{rule: MetricRule{Action: actionRename, Name: "olric_(.*)", NewName: "cache_${1}"}},
+		{rule: MetricRule{Action: actionAddLabel, Label: "env", Value: "prod"}},
+		{rule: MetricRule{Action: actionDrop, Name: "("}, wantErr: true},
+		{rule: MetricRule{Action: actionRename, Name: "olric_up"}, wantErr: true},
+		{rule: MetricRule{Action: actionAddLabel, Label: "0env"}, wantErr: true},
+		{rule: MetricRule{Action: "keep"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		rule := tt.rule
+		if err := rule.compile(); (err != nil) != tt.wantErr {
+			t.Errorf("compile(%+v) error = %v, want error %v", tt.rule, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRelabelGatherer(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []MetricRule
+		want    []string
+		labels  map[string]string
+		wantErr bool
+	}{
+		{
+			name: "none",
+			want: []string{"olric_dmap_length", "olric_member_up", "olric_partition_length"},
+		},
+		{
+			name:  "drop",
+			rules: []MetricRule{{Action: actionDrop, Name: "olric_partition_.*"}},
+			want:  []string{"olric_dmap_length", "olric_member_up"},
+		},
+		{
+			name:  "the name is matched whole",
+			rules: []MetricRule{{Action: actionDrop, Name: "olric_member"}},
+			want:  []string{"olric_dmap_length", "olric_member_up", "olric_partition_length"},
+		},
+		{
+			name: "rename then drop the new name",
+			rules: []MetricRule{
+				{Action: actionRename, Name: "olric_(.*)_length", NewName: "${1}_keys"},
+				{Action: actionDrop, Name: "dmap_keys"},
+			},
+			want: []string{"olric_member_up", "partition_keys"},
+		},
+		{
+			name:   "add label",
+			rules:  []MetricRule{{Action: actionAddLabel, Name: "olric_member_up", Label: "env", Value: "prod"}},
+			want:   []string{"olric_dmap_length", "olric_member_up", "olric_partition_length"},
+			labels: map[string]string{"olric_member_up": "env"},
+		},
+		{
+			name:  "merge",
+			rules: []MetricRule{{Action: actionRename, Name: "olric_(member_up|partition_length)", NewName: "olric_gauge"}},
+			want:  []string{"olric_dmap_length", "olric_gauge"},
+		},
+		{
+			name:    "merge different types",
+			rules:   []MetricRule{{Action: actionRename, Name: ".*", NewName: "olric"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid new name",
+			rules:   []MetricRule{{Action: actionRename, Name: "olric_member_up", NewName: "0up"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.rules {
+				if err := tt.rules[i].compile(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			g := relabelGatherer{g: testGatherer(
+				testFamily("olric_partition_length", dto.MetricType_GAUGE, 2),
+				testFamily("olric_member_up", dto.MetricType_GAUGE, 1),
+				testFamily("olric_dmap_length", dto.MetricType_COUNTER, 2),
+			), rules: tt.rules}
+			families, err := g.Gather()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Gather() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var names []string
+			for _, mf := range families {
+				names = append(names, mf.GetName())
+				label, ok := tt.labels[mf.GetName()]
+				for _, m := range mf.Metric {
+					var found []string
+					for _, l := range m.Label {
+						found = append(found, l.GetName())
+					}
+					if !sort.StringsAreSorted(found) {
+						t.Errorf("labels %v of %s are not sorted", found, mf.GetName())
+					}
+					if ok && sort.SearchStrings(found, label) == len(found) {
+						t.Errorf("%s has no label %s", mf.GetName(), label)
+					}
+				}
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("got families %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buraksezer/olric/stats"
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+)
+
+// reverseLookupTimeout bounds the reverse lookup of an address.
+const reverseLookupTimeout = time.Second
+
+type resolvedName struct {
+	name    string
+	expires time.Time
+}
+
+// nameResolver replaces the IP addresses of the members by their host names,
+// found with reverse lookups cached for ttl. Addresses without a name are
+// kept, and their failed lookups cached as well.
+type nameResolver struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	names map[string]resolvedName
+}
+
+// memberNames resolves the member addresses of all scrapes if it is set.
+var memberNames *nameResolver
+
+func newNameResolver(ttl time.Duration) *nameResolver {
+	return &nameResolver{ttl: ttl, names: make(map[string]resolvedName)}
+}
+
+// lookup returns the name of address, host:port with the host name of the IP
+// of address, or address if it has none.
+func lookup(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) == nil {
+		return address
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), reverseLookupTimeout)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, host)
+	if err != nil || len(names) == 0 {
+		return address
+	}
+	return net.JoinHostPort(strings.TrimSuffix(names[0], "."), port)
+}
+
+// resolve returns the names of addresses, looking up the ones that are not
+// cached in parallel.
+func (r *nameResolver) resolve(addresses map[string]bool) map[string]string {
+	now := time.Now()
+	result := make(map[string]string, len(addresses))
+	r.mu.Lock()
+	var missing []string
+	for address := range addresses {
+		if n, ok := r.names[address]; ok && now.Before(n.expires) {
+			result[address] = n.name
+		} else {
+			missing = append(missing, address)
+		}
+	}
+	for address, n := range r.names {
+		if !now.Before(n.expires) {
+			delete(r.names, address)
+		}
+	}
+	r.mu.Unlock()
+
+	names := make([]string, len(missing))
+	var wg sync.WaitGroup
+	for i, address := range missing {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			names[i] = lookup(address)
+		}(i, address)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, address := range missing {
+		result[address] = names[i]
+		r.names[address] = resolvedName{name: names[i], expires: now.Add(r.ttl)}
+	}
+	return result
+}
+
+// rename replaces the member addresses of results by their names.
+func (r *nameResolver) rename(results []exporter.MemberStats) {
+	addresses := make(map[string]bool)
+	for _, ms := range results {
+		addresses[ms.Member] = true
+		for _, m := range exporter.Members(ms.Stats) {
+			addresses[m] = true
+		}
+	}
+	names := r.resolve(addresses)
+	renameMembers(results, func(address string) string { return names[address] })
+}
+
+// renameMembers replaces the member addresses of results, including the ones
+// of the routing tables and the coordinator, by the names given by name, so
+// that all labels naming a member agree. Members that would get the same name
+// keep their addresses.
+func renameMembers(results []exporter.MemberStats, name func(address string) string) {
+	names := make(map[string]string)
+	members := make(map[string][]string)
+	for _, ms := range results {
+		for _, address := range append(exporter.Members(ms.Stats), ms.Member) {
+			if _, ok := names[address]; ok {
+				continue
+			}
+			n := name(address)
+			if n == "" {
+				n = address
+			}
+			names[address] = n
+			members[n] = append(members[n], address)
+		}
+	}
+	for _, addresses := range members {
+		if len(addresses) > 1 {
+			for _, address := range addresses {
+				names[address] = address
+			}
+		}
+	}
+	rename := func(address string) string {
+		if n, ok := names[address]; ok {
+			return n
+		}
+		return address
+	}
+	for i := range results {
+		results[i].Member = rename(results[i].Member)
+		s := &results[i].Stats
+		s.ClusterCoordinator.Name = rename(s.ClusterCoordinator.Name)
+		for _, partitions := range []map[uint64]stats.Partition{s.Partitions, s.Backups} {
+			for id, p := range partitions {
+				p.Owner.Name = rename(p.Owner.Name)
+				for j := range p.Backups {
+					p.Backups[j].Name = rename(p.Backups[j].Name)
+				}
+				partitions[id] = p
+			}
+		}
+	}
+}
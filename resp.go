@@ -0,0 +1,211 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/buraksezer/olric/stats"
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+)
+
+const transportRESP = "resp"
+
+// respClient fetches the statistics of Olric v0.5 and later, which speak the
+// Redis protocol (RESP). The STATS command returns the statistics as JSON,
+// whose fields are a superset of the ones of stats.Stats, except for the
+// owners of the partitions, and include the DMap command counters. Members
+// are listed with CLUSTER.MEMBERS.
+type respClient struct {
+	target       string
+	dialer       net.Dialer
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func newRESPClient(target string, module Module) *respClient {
+	return &respClient{
+		target:       target,
+		dialer:       net.Dialer{Timeout: module.dialTimeout()},
+		readTimeout:  module.ReadTimeout,
+		writeTimeout: module.WriteTimeout,
+	}
+}
+
+// deadline returns the deadline of an operation bounded by timeout, if it
+// is set, and by the deadline of ctx.
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	d, _ := ctx.Deadline()
+	if timeout > 0 {
+		if t := time.Now().Add(timeout); d.IsZero() || t.Before(d) {
+			d = t
+		}
+	}
+	return d
+}
+
+// do sends a command to address and returns its reply.
+func (r *respClient) do(ctx context.Context, address string, args ...string) (interface{}, error) {
+	inUse := clientConnsInUse.WithLabelValues(r.target)
+	inUse.Inc()
+	defer inUse.Dec()
+	c, err := r.dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn := newTrackedConn(c, r.target)
+	defer conn.Close()
+	conn.SetWriteDeadline(deadline(ctx, r.writeTimeout))
+	// Unblock the connection if ctx is cancelled without a deadline.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(deadline(ctx, r.readTimeout))
+	reply, err := readRESP(bufio.NewReader(conn))
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return reply, err
+}
+
+// respError is an error reply.
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// Reason implements the classification of exporter.ErrorReason.
+func (e respError) Reason() string {
+	return "protocol"
+}
+
+// readRESP reads a RESP2 or RESP3 reply. Simple and bulk strings are
+// returned as strings, integers as int64, arrays as []interface{} and null
+// replies as nil. Error replies are returned as respError.
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed RESP line %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, respError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '#':
+		return payload == "t", nil
+	case '_':
+		return nil, nil
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unsupported RESP type %q", kind)
+}
+
+// Stats implements exporter.StatsClient.
+func (r *respClient) Stats(ctx context.Context, address string) (stats.Stats, error) {
+	s, _, err := r.StatsWithCounters(ctx, address)
+	return s, err
+}
+
+// StatsWithCounters implements exporter.CounterClient.
+func (r *respClient) StatsWithCounters(ctx context.Context, address string) (stats.Stats, *exporter.DMapCounters, error) {
+	reply, err := r.do(ctx, address, "STATS")
+	if err != nil {
+		return stats.Stats{}, nil, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return stats.Stats{}, nil, fmt.Errorf("unexpected STATS reply %T", reply)
+	}
+	return decodeStats([]byte(data))
+}
+
+// Members implements exporter.MemberLister. Every entry of the reply of
+// CLUSTER.MEMBERS starts with the name of the member.
+func (r *respClient) Members(ctx context.Context, address string) ([]string, error) {
+	reply, err := r.do(ctx, address, "CLUSTER.MEMBERS")
+	if err != nil {
+		return nil, err
+	}
+	entries, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected CLUSTER.MEMBERS reply %T", reply)
+	}
+	var members []string
+	for _, entry := range entries {
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) == 0 {
+			return nil, errors.New("unexpected CLUSTER.MEMBERS entry")
+		}
+		name, ok := fields[0].(string)
+		if !ok {
+			return nil, errors.New("unexpected CLUSTER.MEMBERS entry")
+		}
+		members = append(members, name)
+	}
+	return members, nil
+}
+
+func (r *respClient) Close() {}
@@ -0,0 +1,134 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// rulesConfig holds the thresholds of the generated alerting rules.
+type rulesConfig struct {
+	// For is how long a condition must hold before an alert fires.
+	For time.Duration
+
+	// Quorum is the number of members that must be up.
+	Quorum int
+
+	// FragmentationRatio is the garbage to allocated bytes ratio above which
+	// a DMap is considered fragmented.
+	FragmentationRatio float64
+
+	// HitRatio is the DMap get hit ratio below which the cache of a cluster
+	// is considered ineffective.
+	HitRatio float64
+
+	// NormalizedNames selects the metric names that follow the Prometheus
+	// naming conventions.
+	NormalizedNames bool
+}
+
+// metric returns the name of the metric called name by the exporter.
+func (c rulesConfig) metric(name string) string {
+	if c.NormalizedNames {
+		return exporter.NormalizedName(name)
+	}
+	return name
+}
+
+type alertingRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type ruleGroup struct {
+	Name  string         `yaml:"name"`
+	Rules []alertingRule `yaml:"rules"`
+}
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+// generateRules returns a Prometheus rule file with the default alerts.
+func generateRules(c rulesConfig) ruleFile {
+	pending := model.Duration(c.For).String()
+	return ruleFile{Groups: []ruleGroup{{
+		Name: "olric",
+		Rules: []alertingRule{
+			{
+				Alert:  "OlricMemberDown",
+				Expr:   exporter.Namespace + "_member_up == 0",
+				For:    pending,
+				Labels: map[string]string{"severity": "critical"},
+				Annotations: map[string]string{
+					"summary":     "Olric member {{ $labels.member }} is down",
+					"description": "The exporter cannot collect stats from {{ $labels.member }}.",
+				},
+			},
+			{
+				Alert:  "OlricQuorumLost",
+				Expr:   fmt.Sprintf("sum by (job) (%s_member_up) < %d", exporter.Namespace, c.Quorum),
+				For:    pending,
+				Labels: map[string]string{"severity": "critical"},
+				Annotations: map[string]string{
+					"summary":     "Olric cluster {{ $labels.job }} lost its quorum",
+					"description": fmt.Sprintf("Only {{ $value }} members are up, at least %d are required.", c.Quorum),
+				},
+			},
+			{
+				Alert: "OlricDMapFragmentationHigh",
+				Expr: fmt.Sprintf("%s / %s > %s",
+					c.metric(exporter.Namespace+"_dmap_slab_garbage"), c.metric(exporter.Namespace+"_dmap_slab_allocated"),
+					model.SampleValue(c.FragmentationRatio)),
+				For:    pending,
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "DMap {{ $labels.dmap }} on {{ $labels.instance }} is fragmented",
+					"description": "{{ $value | humanizePercentage }} of the storage allocated by the DMap is garbage.",
+				},
+			},
+			{
+				Alert: "OlricHitRatioLow",
+				Expr: fmt.Sprintf("sum by (job) (rate(%[1]s_dmap_get_hits_total[5m])) / (sum by (job) (rate(%[1]s_dmap_get_hits_total[5m])) + sum by (job) (rate(%[1]s_dmap_get_misses_total[5m]))) < %[2]s",
+					exporter.Namespace, model.SampleValue(c.HitRatio)),
+				For:    pending,
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "Olric cluster {{ $labels.job }} has a low hit ratio",
+					"description": "Only {{ $value | humanizePercentage }} of the DMap gets found their key.",
+				},
+			},
+		},
+	}}}
+}
+
+// writeRules writes a Prometheus rule file with the default alerts to w.
+func writeRules(w io.Writer, c rulesConfig) error {
+	data, err := yaml.Marshal(generateRules(c))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,233 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buraksezer/olric/client"
+	"github.com/buraksezer/olric/query"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultSizeBuckets are the default upper bounds of the entry size
+// histogram, from 64 bytes to 1MiB.
+const defaultSizeBuckets = "64,256,1024,4096,16384,65536,262144,1048576"
+
+// parseBuckets parses the comma separated, increasing upper bounds of the
+// buckets of a histogram.
+func parseBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, field := range strings.Split(s, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q", field)
+		}
+		if len(buckets) > 0 && b <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets must be increasing")
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// sizeSerializer decodes a value to the size of its encoding, so that the
+// values of a query are measured without being decoded.
+type sizeSerializer struct{}
+
+func (sizeSerializer) Marshal(v interface{}) ([]byte, error) {
+	return nil, fmt.Errorf("the size serializer cannot encode")
+}
+
+func (sizeSerializer) Unmarshal(data []byte, v interface{}) error {
+	*v.(*interface{}) = len(data)
+	return nil
+}
+
+// sizeSample is the distribution of the sizes of the sampled entries of a
+// DMap.
+type sizeSample struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+var entrySizeDesc = prometheus.NewDesc("olric_dmap_entry_size_bytes",
+	"Distribution of the encoded sizes of a sample of the values of a DMap.",
+	[]string{"dmap"}, nil)
+
+// sizeSampler periodically reads up to limit entries of every DMap of the
+// cluster and exports the distribution of their sizes. The stats of Olric do
+// not include the sizes of the values, and sampling them is expensive, so
+// the sample is taken in the background rather than on every scrape.
+type sizeSampler struct {
+	target  Target
+	module  Module
+	client  *client.Client
+	limit   int
+	buckets []float64
+
+	refresh chan struct{}
+
+	mu      sync.Mutex
+	samples map[string]sizeSample
+}
+
+func newSizeSampler(t Target, module Module, limit int, buckets []float64) (*sizeSampler, error) {
+	if module.Transport != transportOlric {
+		return nil, fmt.Errorf("sampling needs the %s transport", transportOlric)
+	}
+	// The client keeps its addresses, so SRV names are only looked up once.
+	addrs, err := expandSRV(append([]string{t.Address}, t.Seeds...), module.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newOlricClient(&client.Config{
+		Addrs:       addrs,
+		MaxConn:     module.MaxConn,
+		Serializer:  sizeSerializer{},
+		DialTimeout: module.dialTimeout(),
+		KeepAlive:   module.KeepAlive,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Olric: %w", err)
+	}
+	return &sizeSampler{
+		target:  t,
+		module:  module,
+		client:  c,
+		limit:   limit,
+		buckets: buckets,
+		refresh: make(chan struct{}, 1),
+		samples: make(map[string]sizeSample),
+	}, nil
+}
+
+// run samples the DMaps every interval, or right away when triggered.
+func (s *sizeSampler) run(interval time.Duration, logger log.Logger) {
+	for {
+		names := make(map[string]bool)
+		for _, ms := range fetchCluster(context.Background(), s.target, s.module) {
+			if ms.Err != nil {
+				continue
+			}
+			for _, p := range ms.Stats.Partitions {
+				for name := range p.DMaps {
+					names[name] = true
+				}
+			}
+		}
+		samples := make(map[string]sizeSample, len(names))
+		for name := range names {
+			ctx, cancel := context.WithTimeout(context.Background(), s.module.Timeout)
+			sample, err := s.sample(ctx, name)
+			cancel()
+			if err != nil {
+				err = collectionError(err, s.module.Timeout)
+				level.Warn(logger).Log("msg", "Error sampling the entry sizes", "dmap", name, "err", err)
+				continue
+			}
+			samples[name] = sample
+		}
+		s.mu.Lock()
+		s.samples = samples
+		s.mu.Unlock()
+		select {
+		case <-time.After(interval):
+		case <-s.refresh:
+		}
+	}
+}
+
+// trigger makes run take the next sample now.
+func (s *sizeSampler) trigger() {
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// sample reads up to limit entries of the DMap name. The query returns the
+// entries of whole partitions, so more entries may be transferred. The Olric
+// client does not support cancellation, so the query is abandoned when ctx
+// is done, and stops at the next entry it reads.
+func (s *sizeSampler) sample(ctx context.Context, name string) (sizeSample, error) {
+	type result struct {
+		sample sizeSample
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sample, err := s.read(ctx, name)
+		done <- result{sample: sample, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.sample, res.err
+	case <-ctx.Done():
+		return sizeSample{}, ctx.Err()
+	}
+}
+
+func (s *sizeSampler) read(ctx context.Context, name string) (sizeSample, error) {
+	sample := sizeSample{buckets: make(map[float64]uint64, len(s.buckets))}
+	for _, b := range s.buckets {
+		sample.buckets[b] = 0
+	}
+	c, err := s.client.NewDMap(name).Query(query.M{"$onKey": query.M{"$regexMatch": ""}})
+	if err != nil {
+		return sample, err
+	}
+	err = c.Range(func(_ string, value interface{}) bool {
+		size := float64(value.(int))
+		sample.count++
+		sample.sum += size
+		for _, b := range s.buckets {
+			if size <= b {
+				sample.buckets[b]++
+			}
+		}
+		return sample.count < uint64(s.limit) && ctx.Err() == nil
+	})
+	return sample, err
+}
+
+// Describe implements prometheus.Collector.
+func (s *sizeSampler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- entrySizeDesc
+}
+
+// Collect implements prometheus.Collector.
+func (s *sizeSampler) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.samples))
+	for name := range s.samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sample := s.samples[name]
+		ch <- prometheus.MustNewConstHistogram(entrySizeDesc, sample.count, sample.sum, sample.buckets, name)
+	}
+}
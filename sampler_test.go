@@ -0,0 +1,52 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBuckets(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []float64
+		wantErr bool
+	}{
+		{s: "1", want: []float64{1}},
+		{s: "0.005, 0.01,1", want: []float64{0.005, 0.01, 1}},
+		{s: defaultSizeBuckets, want: []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576}},
+		{s: "", wantErr: true},
+		{s: "1,x", wantErr: true},
+		{s: "1,1", wantErr: true},
+		{s: "2,1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseBuckets(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBuckets(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseBuckets(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultDurationBuckets(t *testing.T) {
+	if _, err := parseBuckets(defaultDurationBuckets); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const scrapeIDHeader = "X-Scrape-Id"
+
+type scrapeIDKey struct{}
+
+// newScrapeID returns a random identifier for a scrape.
+func newScrapeID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// scrapeID returns the identifier of the scrape served by ctx.
+func scrapeID(ctx context.Context) string {
+	if id, ok := ctx.Value(scrapeIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// scrapeIDHandler assigns an identifier to every request served by h, so the
+// log lines of a scrape can be correlated. If header is set, the identifier
+// is also returned in the X-Scrape-Id response header.
+func scrapeIDHandler(h http.Handler, header bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newScrapeID()
+		if header {
+			w.Header().Set(scrapeIDHeader, id)
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scrapeIDKey{}, id)))
+	})
+}
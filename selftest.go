@@ -0,0 +1,149 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/buraksezer/olric"
+	"github.com/buraksezer/olric/config"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const selfTestStartTimeout = 30 * time.Second
+
+// freePort returns a TCP port that is free on the loopback interface.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// startEmbeddedOlric starts a single Olric node on the loopback interface and
+// returns it along with its address.
+func startEmbeddedOlric() (*olric.Olric, string, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, "", err
+	}
+	discoveryPort, err := freePort()
+	if err != nil {
+		return nil, "", err
+	}
+
+	c := config.New("local")
+	c.BindAddr = "127.0.0.1"
+	c.BindPort = port
+	c.MemberlistConfig.BindAddr = "127.0.0.1"
+	c.MemberlistConfig.BindPort = discoveryPort
+	c.LogOutput = ioutil.Discard
+	started := make(chan struct{})
+	c.Started = func() {
+		close(started)
+	}
+
+	db, err := olric.New(c)
+	if err != nil {
+		return nil, "", err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- db.Start()
+	}()
+	select {
+	case <-started:
+	case err := <-errCh:
+		return nil, "", fmt.Errorf("embedded Olric node failed: %w", err)
+	case <-time.After(selfTestStartTimeout):
+		return nil, "", fmt.Errorf("embedded Olric node did not start in %s", selfTestStartTimeout)
+	}
+	return db, net.JoinHostPort(c.BindAddr, strconv.Itoa(port)), nil
+}
+
+// selfTest starts an embedded Olric node, scrapes it and checks that every
+// metric family described by the exporter is produced. The result of every
+// check is written to w.
+func selfTest(w io.Writer, module Module, logger log.Logger) error {
+	db, address, err := startEmbeddedOlric()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = db.Shutdown(ctx)
+	}()
+	fmt.Fprintf(w, "Started embedded Olric node on %s\n", address)
+
+	// Store a key, otherwise there are no DMap statistics to export.
+	dm, err := db.NewDMap("olric-exporter-self-test")
+	if err != nil {
+		return err
+	}
+	if err := dm.Put("key", "value"); err != nil {
+		return err
+	}
+
+	e := newExporter(context.Background(), Target{Address: address}, module, logger)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	families, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+	gathered := make(map[string]bool, len(families))
+	for _, mf := range families {
+		gathered[mf.GetName()] = true
+		if down := downMembers(mf); len(down) > 0 {
+			return fmt.Errorf("embedded Olric node could not be scraped")
+		}
+	}
+
+	var missing int
+	for _, info := range e.MetricInfos() {
+		if gathered[info.Name] {
+			fmt.Fprintf(w, "ok      %s\n", info.Name)
+			continue
+		}
+		if info.Cluster {
+			fmt.Fprintf(w, "skipped %s (needs a replicated cluster)\n", info.Name)
+			continue
+		}
+		if info.OnError {
+			fmt.Fprintf(w, "skipped %s (only exported on failures)\n", info.Name)
+			continue
+		}
+		if info.Counted {
+			fmt.Fprintf(w, "skipped %s (needs Olric v0.5 or later)\n", info.Name)
+			continue
+		}
+		fmt.Fprintf(w, "missing %s\n", info.Name)
+		missing++
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d metric families are missing", missing)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// shard is the part of the targets scraped by one of several replicas
+// configured with the same targets.
+type shard struct {
+	index int
+	total int
+}
+
+func (s shard) validate() error {
+	if s.total < 1 || s.index < 0 || s.index >= s.total {
+		return fmt.Errorf("shard index %d must be between 0 and the shard total %d", s.index, s.total)
+	}
+	return nil
+}
+
+// owns reports whether the target at address belongs to the shard. Targets
+// are assigned by rendezvous hashing, so changing the total only moves the
+// targets of the added or removed shards.
+func (s shard) owns(address string) bool {
+	if s.total <= 1 {
+		return true
+	}
+	best, bestScore := 0, uint64(0)
+	for i := 0; i < s.total; i++ {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d|%s", i, address)
+		if score := mix(h.Sum64()); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best == s.index
+}
+
+// mix is the finalizer of MurmurHash3. FNV spreads the differences of the
+// inputs poorly over the high bits, which dominate the comparison of scores.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
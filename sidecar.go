@@ -0,0 +1,51 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	modeStandalone = "standalone"
+	modeSidecar    = "sidecar"
+)
+
+// sidecarEnv maps the labels attached in sidecar mode to the environment
+// variables they are read from. The variables are expected to be populated
+// from the Kubernetes downward API, e.g. POD_NAME from metadata.name.
+var sidecarEnv = map[string]string{
+	"pod":       "POD_NAME",
+	"namespace": "POD_NAMESPACE",
+	"node":      "NODE_NAME",
+}
+
+// sidecarLabels returns the labels identifying the pod the exporter runs in,
+// and the environment variables that are not set.
+func sidecarLabels(getenv func(string) string) (prometheus.Labels, []string) {
+	labels := make(prometheus.Labels)
+	var missing []string
+	for label, env := range sidecarEnv {
+		if v := getenv(env); v != "" {
+			labels[label] = v
+			continue
+		}
+		missing = append(missing, env)
+	}
+	sort.Strings(missing)
+	return labels, missing
+}
@@ -0,0 +1,234 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kvWatchTimeout bounds a watch of a key, after which the key is read again.
+// It keeps a connection that died silently from blocking the reloads.
+const kvWatchTimeout = 5 * time.Minute
+
+// configSource is where the configuration is read from.
+type configSource interface {
+	// read returns the current configuration.
+	read() ([]byte, error)
+
+	// next blocks until the configuration may have changed, and returns it.
+	next() ([]byte, error)
+}
+
+// newConfigSource returns the source of the configuration at path. Paths of
+// the form consul://<host:port>/<key> and etcd://<host:port>/<key> are keys
+// of a Consul or etcd KV store, which are watched for changes. Files are
+// read again every interval.
+func newConfigSource(path string, interval time.Duration) (configSource, error) {
+	u, err := url.Parse(path)
+	if err != nil || (u.Scheme != "consul" && u.Scheme != "etcd") {
+		return fileSource{path: path, interval: interval}, nil
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("%s needs a host and a key", path)
+	}
+	if u.Scheme == "consul" {
+		return &consulSource{addr: u.Host, key: key, token: os.Getenv("CONSUL_HTTP_TOKEN")}, nil
+	}
+	return &etcdSource{addr: u.Host, key: key}, nil
+}
+
+type fileSource struct {
+	path     string
+	interval time.Duration
+}
+
+func (f fileSource) read() ([]byte, error) {
+	return ioutil.ReadFile(f.path)
+}
+
+func (f fileSource) next() ([]byte, error) {
+	time.Sleep(f.interval)
+	return f.read()
+}
+
+// consulSource reads a key of the Consul KV store, and watches it with
+// blocking queries.
+type consulSource struct {
+	addr  string
+	key   string
+	token string
+	index uint64
+}
+
+func (c *consulSource) read() ([]byte, error) {
+	return c.get(0)
+}
+
+func (c *consulSource) next() ([]byte, error) {
+	return c.get(c.index)
+}
+
+// get reads the key, blocking until its index is past index if it is not 0.
+func (c *consulSource) get(index uint64) ([]byte, error) {
+	u := fmt.Sprintf("http://%s/v1/kv/%s?raw", c.addr, c.key)
+	if index > 0 {
+		u += fmt.Sprintf("&index=%d&wait=%ds", index, int(kvWatchTimeout.Seconds()))
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kvWatchTimeout+time.Minute)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// The index must be reset if it goes backwards, e.g. after a restore.
+	if i, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64); err == nil && i >= c.index {
+		c.index = i
+	} else {
+		c.index = 0
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error reading Consul key %s: %s", c.key, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// etcdSource reads a key of etcd through its JSON gateway, and watches it
+// from the revision read last.
+type etcdSource struct {
+	addr     string
+	key      string
+	revision int64
+}
+
+type etcdKV struct {
+	Value       string `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+func (e *etcdSource) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://"+e.addr+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error reading etcd key %s: %s", e.key, resp.Status)
+	}
+	return resp, nil
+}
+
+// value decodes the value of kv and records its revision.
+func (e *etcdSource) value(kv etcdKV) ([]byte, error) {
+	if rev, err := strconv.ParseInt(kv.ModRevision, 10, 64); err == nil {
+		e.revision = rev
+	}
+	return base64.StdEncoding.DecodeString(kv.Value)
+}
+
+func (e *etcdSource) read() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	key := base64.StdEncoding.EncodeToString([]byte(e.key))
+	resp, err := e.post(ctx, "/v3/kv/range", map[string]string{"key": key})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.KVs) == 0 {
+		return nil, fmt.Errorf("etcd key %s does not exist", e.key)
+	}
+	return e.value(result.KVs[0])
+}
+
+// next watches the key from the revision after the one read last. The
+// gateway streams the events as JSON objects.
+func (e *etcdSource) next() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kvWatchTimeout)
+	defer cancel()
+	key := base64.StdEncoding.EncodeToString([]byte(e.key))
+	resp, err := e.post(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{"key": key, "start_revision": e.revision + 1},
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return e.read()
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var msg struct {
+			Result struct {
+				Events []struct {
+					Type string `json:"type"`
+					KV   etcdKV `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() != nil {
+				return e.read()
+			}
+			return nil, err
+		}
+		events := msg.Result.Events
+		if len(events) == 0 {
+			continue
+		}
+		last := events[len(events)-1]
+		value, err := e.value(last.KV)
+		if last.Type == "DELETE" {
+			return nil, errors.New("etcd key " + e.key + " was deleted")
+		}
+		return value, err
+	}
+}
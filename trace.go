@@ -0,0 +1,132 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/buraksezer/olric/stats"
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const traceParentHeader = "traceparent"
+
+// defaultDurationBuckets are the default upper bounds of the stats duration
+// histogram, in seconds, those of prometheus.DefBuckets.
+const defaultDurationBuckets = "0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10"
+
+// statsDuration is replaced by main with the buckets of the flags before it
+// is registered.
+var statsDuration = newStatsDuration(prometheus.DefBuckets)
+
+func newStatsDuration(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "olric_exporter",
+		Name:      "stats_duration_seconds",
+		Help:      "Duration of the stats requests to the members of a target.",
+		Buckets:   buckets,
+	}, []string{"target"})
+}
+
+type traceIDKey struct{}
+
+// traceID returns the trace ID of the W3C traceparent header of r, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01, or the empty
+// string if it has none or it is invalid.
+func traceID(r *http.Request) string {
+	parts := strings.Split(r.Header.Get(traceParentHeader), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 {
+		return ""
+	}
+	id := strings.ToLower(parts[1])
+	if _, err := hex.DecodeString(id); err != nil || id == strings.Repeat("0", 32) {
+		return ""
+	}
+	return id
+}
+
+// withTraceID returns a context carrying the trace ID id, if it is set.
+func withTraceID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// observe records v in o, with the trace ID of ctx as exemplar if any.
+func observe(ctx context.Context, o prometheus.Observer, v float64) {
+	if id, ok := ctx.Value(traceIDKey{}).(string); ok {
+		if eo, ok := o.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": id})
+			return
+		}
+	}
+	o.Observe(v)
+}
+
+// timedClient records the duration of the stats requests of a target.
+type timedClient struct {
+	exporter.StatsClient
+	target string
+}
+
+// timedLister is a timedClient of a client that lists the members.
+type timedLister struct {
+	timedClient
+	exporter.MemberLister
+}
+
+// timedStatsClient is a client returned by timed.
+type timedStatsClient interface {
+	exporter.StatsClient
+	exporter.CounterClient
+}
+
+// timed returns c recording the duration of its stats requests for target,
+// which implements exporter.MemberLister if c does.
+func timed(c exporter.StatsClient, target string) timedStatsClient {
+	tc := timedClient{StatsClient: c, target: target}
+	if l, ok := c.(exporter.MemberLister); ok {
+		return timedLister{timedClient: tc, MemberLister: l}
+	}
+	return tc
+}
+
+// Stats implements exporter.StatsClient.
+func (c timedClient) Stats(ctx context.Context, address string) (stats.Stats, error) {
+	start := time.Now()
+	s, err := c.StatsClient.Stats(ctx, address)
+	observe(ctx, statsDuration.WithLabelValues(c.target), time.Since(start).Seconds())
+	return s, err
+}
+
+// StatsWithCounters implements exporter.CounterClient. No counters are
+// returned if the client of c does not report them.
+func (c timedClient) StatsWithCounters(ctx context.Context, address string) (stats.Stats, *exporter.DMapCounters, error) {
+	cc, ok := c.StatsClient.(exporter.CounterClient)
+	if !ok {
+		s, err := c.Stats(ctx, address)
+		return s, nil, err
+	}
+	start := time.Now()
+	s, counters, err := cc.StatsWithCounters(ctx, address)
+	observe(ctx, statsDuration.WithLabelValues(c.target), time.Since(start).Seconds())
+	return s, counters, err
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/buraksezer/olric"
+	"github.com/buraksezer/olric/stats"
+	"github.com/buraksezer/olric_exporter/pkg/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	transportOlric = "olric"
+	transportHTTP  = "http"
+)
+
+// statsClient is an exporter.StatsClient holding connections.
+type statsClient interface {
+	exporter.StatsClient
+	Close()
+}
+
+// clientVersion is the version of the Olric module the exporter is built
+// with, which defines the protocol and the decoded statistics.
+var clientVersion = func() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/buraksezer/olric" {
+				return strings.TrimPrefix(dep.Version, "v")
+			}
+		}
+	}
+	return olric.ReleaseVersion
+}()
+
+const targetInfoName, targetInfoHelp = "olric_exporter_target_info", "Information about how the exporter talks to the target."
+
+var targetInfoLabels = []string{"protocol", "serializer", "client_version"}
+
+var targetInfoDesc = prometheus.NewDesc(targetInfoName, targetInfoHelp, targetInfoLabels, nil)
+
+// targetInfo exports the transport settings of a module.
+type targetInfo struct {
+	module Module
+}
+
+// Describe implements prometheus.Collector.
+func (t targetInfo) Describe(ch chan<- *prometheus.Desc) {
+	ch <- targetInfoDesc
+}
+
+// Collect implements prometheus.Collector. The http and resp transports
+// always decode JSON.
+func (t targetInfo) Collect(ch chan<- prometheus.Metric) {
+	serializer := "json"
+	if t.module.Transport == transportOlric {
+		serializer = t.module.Serializer
+	}
+	ch <- prometheus.MustNewConstMetric(targetInfoDesc, prometheus.GaugeValue, 1,
+		t.module.Transport, serializer, clientVersion)
+}
+
+// newStatsClient returns a client of the transport of the module.
+func newStatsClient(address string, module Module) (statsClient, error) {
+	switch module.Transport {
+	case transportHTTP:
+		return newHTTPClient(address, module), nil
+	case transportRESP:
+		return newRESPClient(address, module), nil
+	}
+	return newClient(address, module)
+}
+
+// httpClient fetches the statistics as JSON from an HTTP endpoint, e.g. a
+// shim in front of Olric or the /api/v1/stats endpoint of another exporter.
+type httpClient struct {
+	client *http.Client
+	path   string
+	target string
+}
+
+func newHTTPClient(target string, module Module) *httpClient {
+	dialer := &net.Dialer{
+		Timeout:   module.dialTimeout(),
+		KeepAlive: module.KeepAlive,
+	}
+	return &httpClient{
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				c, err := dialer.DialContext(ctx, network, address)
+				if err != nil {
+					return nil, err
+				}
+				return newTrackedConn(c, target), nil
+			},
+			MaxConnsPerHost:       module.MaxConn,
+			MaxIdleConnsPerHost:   module.MaxConn,
+			IdleConnTimeout:       module.KeepAlive,
+			ResponseHeaderTimeout: module.ReadTimeout,
+		}},
+		path:   module.HTTPPath,
+		target: target,
+	}
+}
+
+// trace returns a context counting the waits for a connection of the
+// requests made within ctx.
+func (h *httpClient) trace(ctx context.Context) context.Context {
+	var start time.Time
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { start = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.WasIdle || start.IsZero() {
+				return
+			}
+			clientConnWaits.WithLabelValues(h.target).Inc()
+			clientConnWaitSeconds.WithLabelValues(h.target).Add(time.Since(start).Seconds())
+		},
+	})
+}
+
+// statsURL returns the URL of the statistics served at address, which is a
+// host:port or a URL with an http or https scheme.
+func (h *httpClient) statsURL(address string) string {
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		address = "http://" + address
+	}
+	return strings.TrimSuffix(address, "/") + h.path
+}
+
+// Stats implements exporter.StatsClient.
+func (h *httpClient) Stats(ctx context.Context, address string) (stats.Stats, error) {
+	s, _, err := h.StatsWithCounters(ctx, address)
+	return s, err
+}
+
+// StatsWithCounters implements exporter.CounterClient.
+func (h *httpClient) StatsWithCounters(ctx context.Context, address string) (stats.Stats, *exporter.DMapCounters, error) {
+	var s stats.Stats
+	req, err := http.NewRequest(http.MethodGet, h.statsURL(address), nil)
+	if err != nil {
+		return s, nil, err
+	}
+	inUse := clientConnsInUse.WithLabelValues(h.target)
+	inUse.Inc()
+	defer inUse.Dec()
+	resp, err := h.client.Do(req.WithContext(h.trace(ctx)))
+	if err != nil {
+		return s, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return s, nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return s, nil, err
+	}
+	return decodeStats(data)
+}
+
+func (h *httpClient) Close() {
+	h.client.CloseIdleConnections()
+}
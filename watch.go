@@ -0,0 +1,155 @@
+// Copyright 2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/buraksezer/olric/stats"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+// flattenStats returns the values of s keyed by their dotted path, e.g.
+// Runtime.MemStats.HeapAlloc.
+func flattenStats(s stats.Stats) (map[string]interface{}, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	flatten(values, "", v)
+	return values, nil
+}
+
+func flatten(values map[string]interface{}, prefix string, v interface{}) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			flatten(values, join(key), item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flatten(values, join(strconv.Itoa(i)), item)
+		}
+	default:
+		values[prefix] = v
+	}
+}
+
+// formatValue formats a flattened value, avoiding the exponent notation for
+// numbers.
+func formatValue(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	if v == nil {
+		return "<none>"
+	}
+	return fmt.Sprint(v)
+}
+
+// diff returns a line for every value that differs between prev and cur.
+// Numeric increases are colored green, decreases red and other changes
+// yellow.
+func diff(prev, cur map[string]interface{}, color bool) []string {
+	keys := make([]string, 0, len(cur))
+	for key := range cur {
+		keys = append(keys, key)
+	}
+	for key := range prev {
+		if _, ok := cur[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, key := range keys {
+		old, now := prev[key], cur[key]
+		if old == now {
+			continue
+		}
+		c, change := colorYellow, ""
+		if o, ok := old.(float64); ok {
+			if n, ok := now.(float64); ok {
+				change = " (" + strconv.FormatFloat(n-o, 'f', -1, 64) + ")"
+				c = colorRed
+				if n > o {
+					change = " (+" + strconv.FormatFloat(n-o, 'f', -1, 64) + ")"
+					c = colorGreen
+				}
+			}
+		}
+		line := fmt.Sprintf("%s: %s -> %s%s", key, formatValue(old), formatValue(now), change)
+		if color {
+			line = c + line + colorReset
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// watch polls the statistics of the Olric server at address every interval
+// and writes the changed values to w. It only returns if the statistics
+// cannot be flattened.
+func watch(w io.Writer, address string, module Module, interval time.Duration, color bool) error {
+	var prev map[string]interface{}
+	for {
+		s, err := fetchStats(address, module)
+		if err != nil {
+			line := fmt.Sprintf("%s failed to collect stats: %v", time.Now().Format(time.RFC3339), err)
+			if color {
+				line = colorRed + line + colorReset
+			}
+			fmt.Fprintln(w, line)
+		} else {
+			cur, err := flattenStats(s)
+			if err != nil {
+				return err
+			}
+			if prev == nil {
+				fmt.Fprintf(w, "%s watching %d values of %s\n", time.Now().Format(time.RFC3339), len(cur), address)
+			} else if lines := diff(prev, cur, color); len(lines) > 0 {
+				fmt.Fprintf(w, "%s %d values changed\n", time.Now().Format(time.RFC3339), len(lines))
+				for _, line := range lines {
+					fmt.Fprintf(w, "  %s\n", line)
+				}
+			}
+			prev = cur
+		}
+		time.Sleep(interval)
+	}
+}